@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// A minimal AMQP 0-9-1 client which performs the connection handshake, enough to check that a
+// broker is reachable and accepts the configured credentials and virtual host.
+
+var amqpProtocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+const (
+	amqpFrameMethod = 1
+	amqpFrameEnd    = 0xCE
+)
+
+type amqpConfig struct {
+	Address     string
+	User        string
+	Password    string
+	VirtualHost string
+	// TLS connects over TLS when set, as for amqps.
+	TLS     *tls.Config
+	Timeout time.Duration
+}
+
+// amqpServerInfo is what the broker reports about itself in Connection.Start.
+type amqpServerInfo struct {
+	Product    string
+	Version    string
+	Mechanisms string
+}
+
+type amqpMethod struct {
+	classID  uint16
+	methodID uint16
+	args     []byte
+}
+
+// amqpHandshake opens and cleanly closes a connection.
+func amqpHandshake(config amqpConfig) (*amqpServerInfo, error) {
+	conn, err := net.DialTimeout("tcp", config.Address, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if config.TLS != nil {
+		conn = tls.Client(conn, config.TLS)
+	}
+	defer conn.Close()
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	reader := bufio.NewReader(conn)
+	if _, err := conn.Write(amqpProtocolHeader); err != nil {
+		return nil, err
+	}
+
+	start, err := readAMQPMethod(reader)
+	if err != nil {
+		return nil, err
+	}
+	if start.classID != 10 || start.methodID != 10 {
+		return nil, fmt.Errorf("expected Connection.Start, got method %d.%d", start.classID, start.methodID)
+	}
+	info, err := parseAMQPStart(start.args)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(info.Mechanisms, "PLAIN") {
+		return info, fmt.Errorf("broker does not offer PLAIN authentication (offers %s)", info.Mechanisms)
+	}
+
+	// ask the broker to report authentication failures with Connection.Close instead of dropping
+	// the connection, so that they can be told apart from network errors
+	var startOk bytes.Buffer
+	writeAMQPTable(&startOk, map[string]interface{}{
+		"product":      "dcos-scale-cli",
+		"capabilities": map[string]interface{}{"authentication_failure_close": true},
+	})
+	writeAMQPShortString(&startOk, "PLAIN")
+	writeAMQPLongString(&startOk, "\x00"+config.User+"\x00"+config.Password)
+	writeAMQPShortString(&startOk, "en_US")
+	if err := writeAMQPMethod(conn, 10, 11, startOk.Bytes()); err != nil {
+		return info, err
+	}
+
+	tune, err := readAMQPMethod(reader)
+	if err != nil {
+		return info, fmt.Errorf("broker closed the connection after login, check the credentials: %s", err)
+	}
+	if err := amqpCloseError(tune); err != nil {
+		return info, err
+	}
+	if tune.classID != 10 || tune.methodID != 30 || len(tune.args) < 8 {
+		return info, fmt.Errorf("expected Connection.Tune, got method %d.%d", tune.classID, tune.methodID)
+	}
+	if err := writeAMQPMethod(conn, 10, 31, tune.args[:8]); err != nil {
+		return info, err
+	}
+
+	var open bytes.Buffer
+	writeAMQPShortString(&open, config.VirtualHost)
+	writeAMQPShortString(&open, "")
+	open.WriteByte(0)
+	if err := writeAMQPMethod(conn, 10, 40, open.Bytes()); err != nil {
+		return info, err
+	}
+	openOk, err := readAMQPMethod(reader)
+	if err != nil {
+		return info, err
+	}
+	if err := amqpCloseError(openOk); err != nil {
+		return info, err
+	}
+	if openOk.classID != 10 || openOk.methodID != 41 {
+		return info, fmt.Errorf("expected Connection.Open-Ok, got method %d.%d", openOk.classID, openOk.methodID)
+	}
+
+	var closeArgs bytes.Buffer
+	binary.Write(&closeArgs, binary.BigEndian, uint16(200))
+	writeAMQPShortString(&closeArgs, "bye")
+	binary.Write(&closeArgs, binary.BigEndian, uint32(0))
+	writeAMQPMethod(conn, 10, 50, closeArgs.Bytes())
+	readAMQPMethod(reader)
+	return info, nil
+}
+
+// amqpCloseError returns the reason of a Connection.Close sent by the broker.
+func amqpCloseError(method *amqpMethod) error {
+	if method.classID != 10 || method.methodID != 50 || len(method.args) < 3 {
+		return nil
+	}
+	code := binary.BigEndian.Uint16(method.args)
+	text, _ := readAMQPShortString(bytes.NewReader(method.args[2:]))
+	return fmt.Errorf("broker closed the connection: %d %s", code, text)
+}
+
+func writeAMQPMethod(w io.Writer, classID, methodID uint16, args []byte) error {
+	var payload bytes.Buffer
+	binary.Write(&payload, binary.BigEndian, classID)
+	binary.Write(&payload, binary.BigEndian, methodID)
+	payload.Write(args)
+	var frame bytes.Buffer
+	frame.WriteByte(amqpFrameMethod)
+	binary.Write(&frame, binary.BigEndian, uint16(0))
+	binary.Write(&frame, binary.BigEndian, uint32(payload.Len()))
+	frame.Write(payload.Bytes())
+	frame.WriteByte(amqpFrameEnd)
+	_, err := w.Write(frame.Bytes())
+	return err
+}
+
+func readAMQPMethod(r *bufio.Reader) (*amqpMethod, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(header, amqpProtocolHeader[:7]) {
+		// the broker answers a protocol header it does not support with the one it does
+		r.ReadByte()
+		return nil, fmt.Errorf("broker does not speak AMQP 0-9-1")
+	}
+	size := binary.BigEndian.Uint32(header[3:])
+	if size > 1<<20 {
+		return nil, fmt.Errorf("invalid frame size %d, is this an AMQP broker?", size)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if header[0] != amqpFrameMethod || payload[size] != amqpFrameEnd || size < 4 {
+		return nil, fmt.Errorf("unexpected AMQP frame of type %d", header[0])
+	}
+	return &amqpMethod{
+		classID:  binary.BigEndian.Uint16(payload),
+		methodID: binary.BigEndian.Uint16(payload[2:]),
+		args:     payload[4:size],
+	}, nil
+}
+
+func parseAMQPStart(args []byte) (*amqpServerInfo, error) {
+	r := bytes.NewReader(args)
+	if _, err := r.Seek(2, io.SeekStart); err != nil { // version-major, version-minor
+		return nil, err
+	}
+	properties, err := readAMQPTable(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server properties: %s", err)
+	}
+	mechanisms, err := readAMQPLongString(r)
+	if err != nil {
+		return nil, err
+	}
+	info := &amqpServerInfo{Mechanisms: mechanisms}
+	info.Product, _ = properties["product"].(string)
+	info.Version, _ = properties["version"].(string)
+	return info, nil
+}
+
+func writeAMQPShortString(buf *bytes.Buffer, s string) {
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+}
+
+func writeAMQPLongString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+// writeAMQPTable encodes a field table holding strings, booleans and nested tables.
+func writeAMQPTable(buf *bytes.Buffer, table map[string]interface{}) {
+	var fields bytes.Buffer
+	for key, value := range table {
+		writeAMQPShortString(&fields, key)
+		switch v := value.(type) {
+		case string:
+			fields.WriteByte('S')
+			writeAMQPLongString(&fields, v)
+		case bool:
+			fields.WriteByte('t')
+			if v {
+				fields.WriteByte(1)
+			} else {
+				fields.WriteByte(0)
+			}
+		case map[string]interface{}:
+			fields.WriteByte('F')
+			writeAMQPTable(&fields, v)
+		}
+	}
+	binary.Write(buf, binary.BigEndian, uint32(fields.Len()))
+	buf.Write(fields.Bytes())
+}
+
+func readAMQPShortString(r *bytes.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, length)
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
+
+func readAMQPLongString(r *bytes.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if int64(length) > int64(r.Len()) {
+		return "", fmt.Errorf("string length %d overruns the frame", length)
+	}
+	s := make([]byte, length)
+	_, err := io.ReadFull(r, s)
+	return string(s), err
+}
+
+func readAMQPTable(r *bytes.Reader) (map[string]interface{}, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(r.Len()) {
+		return nil, fmt.Errorf("table length %d overruns the frame", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	fields := bytes.NewReader(data)
+	table := map[string]interface{}{}
+	for fields.Len() > 0 {
+		key, err := readAMQPShortString(fields)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readAMQPFieldValue(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		table[key] = value
+	}
+	return table, nil
+}
+
+// readAMQPFieldValue decodes string and table values and skips over the others.
+func readAMQPFieldValue(r *bytes.Reader) (interface{}, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	skip := 0
+	switch kind {
+	case 'S', 'x':
+		return readAMQPLongString(r)
+	case 'F':
+		return readAMQPTable(r)
+	case 'A':
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		skip = int(length)
+	case 't', 'b', 'B':
+		skip = 1
+	case 's', 'u':
+		skip = 2
+	case 'I', 'i', 'f':
+		skip = 4
+	case 'D':
+		skip = 5
+	case 'l', 'd', 'T':
+		skip = 8
+	case 'V':
+	default:
+		return nil, fmt.Errorf("unknown field type %q", kind)
+	}
+	if skip > r.Len() {
+		return nil, fmt.Errorf("field overruns the table")
+	}
+	_, err = r.Seek(int64(skip), io.SeekCurrent)
+	return nil, err
+}
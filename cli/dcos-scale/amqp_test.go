@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// amqpStandIn is a broker that goes through the AMQP 0-9-1 connection handshake for one user
+// and the virtual hosts in vhosts.
+type amqpStandIn struct {
+	listener net.Listener
+	user     string
+	password string
+	vhosts   map[string]bool
+	// opened receives the virtual host of each opened connection.
+	opened chan string
+}
+
+func startAMQPStandIn(t *testing.T, user, password string, vhosts ...string) *amqpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &amqpStandIn{listener: listener, user: user, password: password, vhosts: map[string]bool{}, opened: make(chan string, 10)}
+	for _, vhost := range vhosts {
+		s.vhosts[vhost] = true
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *amqpStandIn) Close() {
+	s.listener.Close()
+}
+
+func (s *amqpStandIn) config(user, password, vhost string) amqpConfig {
+	return amqpConfig{Address: s.listener.Addr().String(), User: user, Password: password, VirtualHost: vhost, Timeout: 5 * time.Second}
+}
+
+func amqpClose(conn net.Conn, code uint16, text string) {
+	var args bytes.Buffer
+	binary.Write(&args, binary.BigEndian, code)
+	writeAMQPShortString(&args, text)
+	binary.Write(&args, binary.BigEndian, uint32(0))
+	writeAMQPMethod(conn, 10, 50, args.Bytes())
+}
+
+func (s *amqpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	header := make([]byte, len(amqpProtocolHeader))
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	if !bytes.Equal(header, amqpProtocolHeader) {
+		conn.Write(amqpProtocolHeader)
+		return
+	}
+
+	var start bytes.Buffer
+	start.Write([]byte{0, 9})
+	writeAMQPTable(&start, map[string]interface{}{"product": "RabbitMQ", "version": "3.7.8"})
+	writeAMQPLongString(&start, "AMQPLAIN PLAIN")
+	writeAMQPLongString(&start, "en_US")
+	writeAMQPMethod(conn, 10, 10, start.Bytes())
+
+	startOk, err := readAMQPMethod(reader)
+	if err != nil || startOk.classID != 10 || startOk.methodID != 11 {
+		return
+	}
+	args := bytes.NewReader(startOk.args)
+	properties, err := readAMQPTable(args)
+	if err != nil {
+		return
+	}
+	capabilities, _ := properties["capabilities"].(map[string]interface{})
+	mechanism, _ := readAMQPShortString(args)
+	response, _ := readAMQPLongString(args)
+	if mechanism != "PLAIN" || response != "\x00"+s.user+"\x00"+s.password {
+		if capabilities != nil {
+			amqpClose(conn, 403, "ACCESS_REFUSED - Login was refused using authentication mechanism PLAIN")
+		}
+		return
+	}
+
+	var tune bytes.Buffer
+	binary.Write(&tune, binary.BigEndian, uint16(2047))
+	binary.Write(&tune, binary.BigEndian, uint32(131072))
+	binary.Write(&tune, binary.BigEndian, uint16(60))
+	writeAMQPMethod(conn, 10, 30, tune.Bytes())
+	if tuneOk, err := readAMQPMethod(reader); err != nil || tuneOk.methodID != 31 {
+		return
+	}
+
+	open, err := readAMQPMethod(reader)
+	if err != nil || open.methodID != 40 {
+		return
+	}
+	vhost, _ := readAMQPShortString(bytes.NewReader(open.args))
+	if !s.vhosts[vhost] {
+		amqpClose(conn, 530, "NOT_ALLOWED - vhost "+vhost+" not found")
+		return
+	}
+	var openOk bytes.Buffer
+	writeAMQPShortString(&openOk, "")
+	writeAMQPMethod(conn, 10, 41, openOk.Bytes())
+	s.opened <- vhost
+
+	if close, err := readAMQPMethod(reader); err == nil && close.methodID == 50 {
+		writeAMQPMethod(conn, 10, 51, nil)
+	}
+}
+
+func TestAMQPHandshake(t *testing.T) {
+	broker := startAMQPStandIn(t, "scale", "secret", "/")
+	defer broker.Close()
+	info, err := amqpHandshake(broker.config("scale", "secret", "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Product != "RabbitMQ" || info.Version != "3.7.8" {
+		t.Errorf("server info is %+v", info)
+	}
+	if vhost := <-broker.opened; vhost != "/" {
+		t.Errorf("opened vhost %q", vhost)
+	}
+}
+
+func TestAMQPHandshakeRefusals(t *testing.T) {
+	broker := startAMQPStandIn(t, "scale", "secret", "/")
+	defer broker.Close()
+	tests := []struct {
+		user, password, vhost string
+		expected              string
+	}{
+		{"scale", "wrong", "/", "403 ACCESS_REFUSED"},
+		{"scale", "secret", "scale", "530 NOT_ALLOWED"},
+	}
+	for _, test := range tests {
+		_, err := amqpHandshake(broker.config(test.user, test.password, test.vhost))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("handshake as %s on vhost %s returned %v, expected %s", test.user, test.vhost, err, test.expected)
+		}
+	}
+}
+
+func TestAMQPHandshakeNotABroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		conn.Close()
+	}()
+	if _, err := amqpHandshake(amqpConfig{Address: listener.Addr().String(), Timeout: 5 * time.Second}); err == nil {
+		t.Error("handshake with a server that is not a broker returned no error")
+	}
+}
+
+func TestProbeBrokerReadsURLFromSecret(t *testing.T) {
+	broker := startAMQPStandIn(t, "scale", "secret", "scale")
+	defer broker.Close()
+	_, restore := withSecretStore(map[string]string{"scale/broker-url": "amqp://scale:secret@" + broker.listener.Addr().String() + "/scale"})
+	defer restore()
+	options := map[string]interface{}{
+		"messaging.broker-url":        "",
+		"messaging.broker-url-secret": "scale/broker-url",
+	}
+	result := probeBroker(options, 5*time.Second)
+	if !result.OK || !result.External || result.Address != broker.listener.Addr().String() {
+		t.Errorf("probe returned %+v", result)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// apply makes the live Scale configuration match a directory laid out like an export. Kinds
+// without a directory are left alone, so that eg only workspaces can be managed from git.
+
+const (
+	applyCreate = "create"
+	applyUpdate = "update"
+	applyNoop   = "no-op"
+	applyPrune  = "prune"
+)
+
+// applyAction is one step of an apply plan.
+type applyAction struct {
+	Kind   string   `json:"kind" yaml:"kind"`
+	Key    string   `json:"key" yaml:"key"`
+	Action string   `json:"action" yaml:"action"`
+	File   string   `json:"file,omitempty" yaml:"file,omitempty"`
+	Fields []string `json:"fields,omitempty" yaml:"fields,omitempty"`
+	// Skipped explains why a prune is not carried out.
+	Skipped string `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+
+	id     string
+	object map[string]interface{}
+}
+
+// readScaleObjectFiles reads the objects of a kind from dir/<kind>/*.yaml, keyed by identity. It
+// returns false when the kind has no directory.
+func readScaleObjectFiles(adapter *scaleAPIAdapter, dir string, kind *scaleObjectKind) (map[string]*applyAction, bool, error) {
+	kindDir := filepath.Join(dir, kind.Name)
+	if info, err := os.Stat(kindDir); err != nil || !info.IsDir() {
+		return nil, false, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(kindDir, "*.yaml"))
+	if err != nil {
+		return nil, false, err
+	}
+	objects := map[string]*applyAction{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, false, err
+		}
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, false, fmt.Errorf("Failed to parse %s: %s", path, err)
+		}
+		value, err := jsonValue(raw)
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to parse %s: %s", path, err)
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false, fmt.Errorf("%s does not hold a %s object", path, kind.Name)
+		}
+		for _, field := range kind.keyFields(adapter) {
+			if optionIsEmpty(object[field]) {
+				return nil, false, fmt.Errorf("%s has no %s", path, field)
+			}
+		}
+		key := kind.key(adapter, object)
+		if other, ok := objects[key]; ok {
+			return nil, false, fmt.Errorf("%s and %s both define %s %s", other.File, path, kind.Name, key)
+		}
+		objects[key] = &applyAction{Kind: kind.Name, Key: key, File: path, object: kind.stripServerFields(adapter, object)}
+	}
+	return objects, true, nil
+}
+
+// changedFields returns the top level fields of the desired object whose values differ from the
+// live ones. Fields the desired object leaves out are left as they are.
+func changedFields(live, desired map[string]interface{}) []string {
+	fields := []string{}
+	for field, value := range desired {
+		if !optionValuesEqual(live[field], value) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// planApply compares the files in dir with the live objects and returns the actions in the order
+// they are to be carried out: creates and updates in dependency order, then prunes in reverse.
+func planApply(adapter *scaleAPIAdapter, dir string, prune bool, fetch func(kind *scaleObjectKind) (map[string]*scaleObject, error)) ([]*applyAction, error) {
+	actions := []*applyAction{}
+	prunes := []*applyAction{}
+	for i := range scaleObjectKinds {
+		kind := &scaleObjectKinds[i]
+		desired, managed, err := readScaleObjectFiles(adapter, dir, kind)
+		if err != nil {
+			return nil, err
+		}
+		if !managed {
+			continue
+		}
+		live, err := fetch(kind)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(desired))
+		for key := range desired {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			action := desired[key]
+			current, ok := live[key]
+			if !ok {
+				action.Action = applyCreate
+			} else {
+				action.id = current.ID
+				action.Fields = changedFields(kind.stripServerFields(adapter, current.Object), action.object)
+				// a definition of a retired object puts it back into use
+				if kind.retired(current.Object) {
+					for field, value := range kind.Reactivate {
+						action.object[field] = value
+						action.Fields = append(action.Fields, field)
+					}
+					sort.Strings(action.Fields)
+				}
+				action.Action = applyNoop
+				if len(action.Fields) != 0 {
+					action.Action = applyUpdate
+				}
+			}
+			actions = append(actions, action)
+		}
+		kindPrunes := []*applyAction{}
+		for _, key := range sortedObjectKeys(live) {
+			if _, ok := desired[key]; ok || kind.retired(live[key].Object) {
+				continue
+			}
+			action := &applyAction{Kind: kind.Name, Key: key, Action: applyPrune, id: live[key].ID, object: live[key].Object}
+			if !prune {
+				action.Skipped = "pass --prune to retire it"
+			} else if kind.Retire == nil {
+				action.Skipped = "Scale cannot retire " + kind.Name
+			}
+			kindPrunes = append(kindPrunes, action)
+		}
+		prunes = append(kindPrunes, prunes...)
+	}
+	return append(actions, prunes...), nil
+}
+
+// retired returns whether the object has already been taken out of use.
+func (k *scaleObjectKind) retired(object map[string]interface{}) bool {
+	if k.Retire == nil {
+		return false
+	}
+	for field, value := range k.Retire {
+		if !optionValuesEqual(object[field], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// carryOut performs one action against the Scale API.
+func (a *applyAction) carryOut() error {
+	kind, err := scaleObjectKindNamed(a.Kind)
+	if err != nil {
+		return err
+	}
+	adapter, err := scaleAPI()
+	if err != nil {
+		return err
+	}
+	var method, path string
+	var body interface{}
+	switch a.Action {
+	case applyCreate:
+		method, path, body = "POST", kind.Name+"/", adapter.CreatePayload(kind, a.object)
+	case applyUpdate:
+		// send the changed fields only, leaving those Scale manages alone
+		changes := map[string]interface{}{}
+		for _, field := range a.Fields {
+			changes[field] = a.object[field]
+		}
+		method, path, body = "PATCH", adapter.DetailPath(kind, a.id, a.object), changes
+	case applyPrune:
+		method, path, body = "PATCH", adapter.DetailPath(kind, a.id, a.object), kind.Retire
+	default:
+		return nil
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = scaleAPIRequest(method, path, payload)
+	return err
+}
+
+func (a *applyAction) pending() bool {
+	return a.Action != applyNoop && len(a.Skipped) == 0
+}
+
+func printApplyPlan(actions []*applyAction) {
+	rows := [][]string{}
+	counts := map[string]int{}
+	for _, action := range actions {
+		detail := action.Skipped
+		if action.Action == applyUpdate {
+			detail = "changes " + strings.Join(action.Fields, ", ")
+		}
+		rows = append(rows, []string{action.Action, action.Kind, action.Key, detail})
+		if len(action.Skipped) == 0 {
+			counts[action.Action]++
+		}
+	}
+	printTable([]string{"ACTION", "KIND", "OBJECT", "DETAIL"}, rows)
+	client.PrintMessage("\nPlan: %d to create, %d to update, %d unchanged, %d to prune.",
+		counts[applyCreate], counts[applyUpdate], counts[applyNoop], counts[applyPrune])
+}
+
+type applyHandler struct {
+	dir    string
+	prune  bool
+	dryRun bool
+	yes    bool
+}
+
+func (cmd *applyHandler) handleApply(c *kingpin.ParseContext) error {
+	// the files are keyed by the identity fields of the version the instance serves
+	adapter, err := scaleAPI()
+	if err != nil {
+		return err
+	}
+	actions, err := planApply(adapter, cmd.dir, cmd.prune, fetchScaleObjects)
+	if err != nil {
+		return err
+	}
+	if printed, err := printStructured(actions); printed {
+		if err != nil || cmd.dryRun {
+			return err
+		}
+	} else {
+		printApplyPlan(actions)
+	}
+	pending := 0
+	for _, action := range actions {
+		if action.pending() {
+			pending++
+		}
+	}
+	if cmd.dryRun || pending == 0 {
+		return nil
+	}
+	if !cmd.yes && !confirmTyped(os.Stdin, fmt.Sprintf("\nThis will apply %d changes to the Scale configuration.", pending), config.ServiceName) {
+		return fmt.Errorf("Apply cancelled")
+	}
+	for _, action := range actions {
+		if !action.pending() {
+			continue
+		}
+		if err := action.carryOut(); err != nil {
+			return fmt.Errorf("Failed to %s %s %s: %s", action.Action, action.Kind, action.Key, err)
+		}
+		client.PrintMessage("%s %s %s: done", action.Action, action.Kind, action.Key)
+	}
+	return nil
+}
+
+func handleApplySection(app *kingpin.Application) {
+	cmd := &applyHandler{}
+	apply := app.Command("apply", "Make the Scale configuration match a directory of YAML definitions, as written by export").Action(cmd.handleApply)
+	apply.Flag("filename", "Directory holding a subdirectory per kind").Short('f').Required().StringVar(&cmd.dir)
+	apply.Flag("prune", "Retire objects which have no definition, for the kinds in the directory").BoolVar(&cmd.prune)
+	apply.Flag("dry-run", "Print the plan without applying it").BoolVar(&cmd.dryRun)
+	apply.Flag("yes", "Do not ask for confirmation").BoolVar(&cmd.yes)
+}
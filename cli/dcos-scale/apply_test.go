@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChangedFieldsLeavesOutMissingFields(t *testing.T) {
+	live := map[string]interface{}{"name": "raw", "title": "Raw", "description": "Landing zone", "base_url": "http://raw"}
+	desired := map[string]interface{}{"name": "raw", "title": "Raw data"}
+	if fields := changedFields(live, desired); !reflect.DeepEqual(fields, []string{"title"}) {
+		t.Errorf("changed fields are %v, expected only title", fields)
+	}
+}
+
+// writeScaleObjectFile writes one definition the way export lays it out.
+func writeScaleObjectFile(t *testing.T, dir, kind, name, definition string) {
+	if err := os.MkdirAll(filepath.Join(dir, kind), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, kind, name), []byte(definition), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyUpdatesOnlyFieldsInFiles(t *testing.T) {
+	adapter := scaleAPIAdapterNamed(t, "v6")
+	dir, err := ioutil.TempDir("", "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// a hand written file holding only some of the fields
+	writeScaleObjectFile(t, dir, "workspaces", "raw.yaml", "name: raw\ntitle: Raw data\n")
+	writeScaleObjectFile(t, dir, "workspaces", "old.yaml", "name: old\ntitle: Old\n")
+
+	actions, err := planApply(adapter, dir, false, fetchLiveObjects(t, adapter, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := map[string]*applyAction{}
+	for _, action := range actions {
+		byKey[action.Key] = action
+	}
+	raw := byKey["raw"]
+	if raw == nil || raw.Action != applyUpdate || !reflect.DeepEqual(raw.Fields, []string{"title"}) {
+		t.Errorf("raw is planned as %+v, expected an update of its title only", raw)
+	}
+	// old is retired on the instance, and its file brings it back
+	old := byKey["old"]
+	if old == nil || old.Action != applyUpdate || !reflect.DeepEqual(old.Fields, []string{"is_active"}) || old.object["is_active"] != true {
+		t.Errorf("old is planned as %+v, expected an update reactivating it", old)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// The broker commands inspect Scale's message queues through the RabbitMQ management API: that of
+// the rabbitmq pod on its management port, or that of the external broker messaging.broker-url
+// points at when it is RabbitMQ. broker check works with any transport Scale accepts.
+
+type brokerHandler struct {
+	optionsFile   string
+	managementURL string
+	vhost         string
+	timeout       time.Duration
+	queue         string
+	yes           bool
+}
+
+// management returns a client for the broker's management API and the virtual host Scale uses.
+func (cmd *brokerHandler) management() (*rabbitmqManagement, string, error) {
+	options, err := serviceOptions(cmd.optionsFile)
+	if err != nil {
+		return nil, "", err
+	}
+	target, err := resolveBrokerTarget(newSecretStore(), options)
+	if err != nil {
+		return nil, "", err
+	}
+	if target == nil {
+		return nil, "", fmt.Errorf("messaging.broker-url does not point at a RabbitMQ broker")
+	}
+	management := &rabbitmqManagement{BaseURL: target.managementURL(), User: target.User, Password: target.Password, Timeout: cmd.timeout}
+	if len(cmd.managementURL) != 0 {
+		management.BaseURL = cmd.managementURL
+	}
+	vhost := target.VirtualHost
+	if len(cmd.vhost) != 0 {
+		vhost = cmd.vhost
+	}
+	return management, vhost, nil
+}
+
+func formatRate(rate rabbitmqRate) string {
+	return fmt.Sprintf("%.1f/s", rate.Rate)
+}
+
+func (cmd *brokerHandler) handleQueues(c *kingpin.ParseContext) error {
+	management, vhost, err := cmd.management()
+	if err != nil {
+		return err
+	}
+	queues, err := management.queues(vhost)
+	if err != nil {
+		return fmt.Errorf("Failed to list the queues of virtual host %s: %s", vhost, err)
+	}
+	if printed, err := printStructured(queues); printed {
+		return err
+	}
+	if len(queues) == 0 {
+		client.PrintMessage("Virtual host %s has no queues.", vhost)
+		return nil
+	}
+	rows := [][]string{}
+	for _, queue := range queues {
+		rows = append(rows, []string{
+			queue.Name,
+			fmt.Sprint(queue.Messages),
+			fmt.Sprint(queue.MessagesReady),
+			fmt.Sprint(queue.MessagesUnacknowledged),
+			fmt.Sprint(queue.Consumers),
+			formatRate(queue.MessageStats.PublishDetails),
+			formatRate(queue.MessageStats.DeliverGetDetails),
+		})
+	}
+	printTable([]string{"QUEUE", "MESSAGES", "READY", "UNACKED", "CONSUMERS", "PUBLISH", "DELIVER"}, rows)
+	return nil
+}
+
+func (cmd *brokerHandler) handlePurge(c *kingpin.ParseContext) error {
+	management, vhost, err := cmd.management()
+	if err != nil {
+		return err
+	}
+	queue, err := management.queue(vhost, cmd.queue)
+	if isStatus(err, 404) {
+		return fmt.Errorf("Virtual host %s has no queue %s", vhost, cmd.queue)
+	}
+	if err != nil {
+		return err
+	}
+	if queue.MessagesReady == 0 {
+		client.PrintMessage("Queue %s has no ready messages.", queue.Name)
+		return nil
+	}
+	prompt := fmt.Sprintf("This will drop the %d ready messages of queue %s, which Scale will never process.", queue.MessagesReady, queue.Name)
+	if !cmd.yes && !confirmTyped(os.Stdin, prompt, queue.Name) {
+		return fmt.Errorf("Purge cancelled")
+	}
+	if err := management.purge(vhost, queue.Name); err != nil {
+		return fmt.Errorf("Failed to purge queue %s: %s", queue.Name, err)
+	}
+	client.PrintMessage("Purged queue %s.", queue.Name)
+	if queue.MessagesUnacknowledged != 0 {
+		client.PrintMessage("%d messages delivered to consumers but not acknowledged were kept.", queue.MessagesUnacknowledged)
+	}
+	return nil
+}
+
+func (cmd *brokerHandler) handleStats(c *kingpin.ParseContext) error {
+	management, _, err := cmd.management()
+	if err != nil {
+		return err
+	}
+	overview, err := management.overview()
+	if err != nil {
+		return err
+	}
+	if printed, err := printStructured(overview); printed {
+		return err
+	}
+	client.PrintMessage("Broker:       RabbitMQ %s (%s)", overview.RabbitMQVersion, overview.ClusterName)
+	client.PrintMessage("Connections:  %d", overview.ObjectTotals.Connections)
+	client.PrintMessage("Channels:     %d", overview.ObjectTotals.Channels)
+	client.PrintMessage("Queues:       %d", overview.ObjectTotals.Queues)
+	client.PrintMessage("Consumers:    %d", overview.ObjectTotals.Consumers)
+	client.PrintMessage("Messages:     %d (%d ready, %d unacknowledged)", overview.QueueTotals.Messages,
+		overview.QueueTotals.MessagesReady, overview.QueueTotals.MessagesUnacknowledged)
+	client.PrintMessage("Publish rate: %s", formatRate(overview.MessageStats.PublishDetails))
+	client.PrintMessage("Deliver rate: %s", formatRate(overview.MessageStats.DeliverGetDetails))
+	return nil
+}
+
+// brokerCheck is the outcome of checking one of the URLs in messaging.broker-url.
+type brokerCheck struct {
+	URL    string    `json:"url" yaml:"url"`
+	Parsed *kombuURL `json:"parsed" yaml:"parsed"`
+	OK     bool      `json:"ok" yaml:"ok"`
+	Detail string    `json:"detail" yaml:"detail"`
+}
+
+// brokerHandshake connects to the broker the way Scale would, for the transports which have a server.
+func brokerHandshake(parsed *kombuURL, timeout time.Duration) (bool, string) {
+	var tlsConfig *tls.Config
+	if parsed.TLS() {
+		tlsConfig = brokerTLSConfig(parsed.Address())
+	}
+	switch parsed.Transport {
+	case "amqp":
+		info, err := amqpHandshake(amqpConfig{
+			Address:     parsed.Address(),
+			User:        parsed.User,
+			Password:    parsed.Password,
+			VirtualHost: parsed.VirtualHost,
+			TLS:         tlsConfig,
+			Timeout:     timeout,
+		})
+		if err != nil {
+			return false, err.Error()
+		}
+		return true, fmt.Sprintf("connection opened on vhost %s, %s %s", parsed.VirtualHost, info.Product, info.Version)
+	case "redis":
+		version, err := redisHandshake(redisConfig{Address: parsed.Address(), Password: parsed.Password, DB: parsed.DB, TLS: tlsConfig, Timeout: timeout})
+		if err != nil {
+			return false, err.Error()
+		}
+		return true, fmt.Sprintf("database %d selected, Redis %s", parsed.DB, version)
+	}
+	return true, fmt.Sprintf("parsed, no connection check for %s", parsed.Scheme)
+}
+
+func (cmd *brokerHandler) handleCheck(c *kingpin.ParseContext) error {
+	options, err := serviceOptions(cmd.optionsFile)
+	if err != nil {
+		return err
+	}
+	brokerURL, err := secretOptionValue(newSecretStore(), options, "broker-url")
+	if err != nil {
+		return err
+	}
+	if len(brokerURL) == 0 {
+		// the URL scale-deploy hands the scheduler for the rabbitmq pod
+		brokerURL = fmt.Sprintf("amqp://guest:guest@%s//", taskAddress("rabbitmq", "launch", brokerPort))
+	}
+	urls, err := parseKombuURLs(brokerURL)
+	if err != nil {
+		return err
+	}
+	checks := []brokerCheck{}
+	failed := 0
+	for _, parsed := range urls {
+		check := brokerCheck{URL: parsed.Masked(), Parsed: parsed}
+		check.OK, check.Detail = brokerHandshake(parsed, cmd.timeout)
+		if !check.OK {
+			failed++
+		}
+		checks = append(checks, check)
+	}
+	if printed, err := printStructured(checks); !printed {
+		for i, check := range checks {
+			if i != 0 {
+				client.PrintMessage("")
+			}
+			parsed := check.Parsed
+			client.PrintMessage("URL:          %s", check.URL)
+			client.PrintMessage("Transport:    %s", parsed.Transport)
+			switch parsed.Transport {
+			case "amqp":
+				client.PrintMessage("Address:      %s", parsed.Address())
+				client.PrintMessage("User:         %s", parsed.User)
+				client.PrintMessage("Virtual host: %s", parsed.VirtualHost)
+				client.PrintMessage("TLS:          %t", parsed.TLS())
+			case "redis":
+				client.PrintMessage("Address:      %s", parsed.Address())
+				client.PrintMessage("Database:     %d", parsed.DB)
+				client.PrintMessage("TLS:          %t", parsed.TLS())
+			case "sqs":
+				client.PrintMessage("Region:       %s", parsed.Region)
+				client.PrintMessage("Queue:        %s", parsed.QueueName)
+				if len(parsed.User) == 0 {
+					client.PrintMessage("Credentials:  instance role")
+				} else {
+					client.PrintMessage("Credentials:  access key %s", parsed.User)
+				}
+			}
+			status := "OK"
+			if !check.OK {
+				status = "FAIL"
+			}
+			client.PrintMessage("Check:        %s, %s", status, check.Detail)
+		}
+	} else if err != nil {
+		return err
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d brokers failed the check", failed, len(checks))
+	}
+	return nil
+}
+
+func handleBrokerSection(app *kingpin.Application) {
+	cmd := &brokerHandler{}
+	broker := app.Command("broker", "Inspect Scale's message queues on the RabbitMQ broker")
+	broker.Flag("options", "Options file to take the broker URL from instead of the installed service").StringVar(&cmd.optionsFile)
+	broker.Flag("management-url", "URL of the RabbitMQ management API, defaults to the management port of the broker").StringVar(&cmd.managementURL)
+	broker.Flag("vhost", "Virtual host to use, defaults to that of the broker URL").StringVar(&cmd.vhost)
+	broker.Flag("timeout", "Timeout for management API requests").Default("10s").DurationVar(&cmd.timeout)
+
+	broker.Command("queues", "List the queues with their depth, consumers and message rates").Action(cmd.handleQueues)
+
+	purge := broker.Command("purge", "Drop the ready messages of a queue").Action(cmd.handlePurge)
+	purge.Arg("queue", "Name of the queue").Required().StringVar(&cmd.queue)
+	purge.Flag("yes", "Do not ask for confirmation").BoolVar(&cmd.yes)
+
+	broker.Command("stats", "Show the broker's connection, queue and message totals").Action(cmd.handleStats)
+
+	broker.Command("check", "Parse messaging.broker-url and connect to the broker it names").Action(cmd.handleCheck)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// marathonAppResources is what marathon.json.mustache reserves for the service scheduler itself.
+var marathonAppResources = mesosResources{CPUs: 1, Mem: 1024}
+
+// podResourceOptions are the options each pod of svc.yml takes its count and resources from.
+type podResourceOptions struct {
+	Count string
+	CPUs  string
+	Mem   string
+	// External is the option which, when set, points Scale at an external service instead of
+	// deploying the pod.
+	External string
+}
+
+var defaultPodResourceOptions = map[string]podResourceOptions{
+	"db":        {CPUs: "db.cpu", Mem: "db.memory", External: "db.db-host"},
+	"logstash":  {CPUs: "logging.cpu", Mem: "logging.memory", External: "logging.logstash-address"},
+	"rabbitmq":  {CPUs: "messaging.cpu", Mem: "messaging.mem", External: "messaging.broker-url"},
+	"scheduler": {Count: "node.count", CPUs: "node.cpus", Mem: "node.mem"},
+	"webserver": {CPUs: "webserver.cpu", Mem: "webserver.memory"},
+}
+
+// resourceOptionDefaults mirrors the defaults universe/config.json gives the resource options, for
+// options files which leave them out.
+var resourceOptionDefaults = map[string]float64{
+	"db.cpu":           0.1,
+	"db.memory":        256,
+	"logging.cpu":      0.1,
+	"logging.memory":   256,
+	"messaging.cpu":    0.5,
+	"messaging.mem":    512,
+	"node.cpus":        0.1,
+	"node.mem":         252,
+	"webserver.cpu":    1,
+	"webserver.memory": 512,
+}
+
+// podReservation is what one pod type reserves, per instance and in total.
+type podReservation struct {
+	Pod      string         `json:"pod" yaml:"pod"`
+	Count    int            `json:"count" yaml:"count"`
+	Instance mesosResources `json:"instance" yaml:"instance"`
+	Total    mesosResources `json:"total" yaml:"total"`
+}
+
+type capacityReport struct {
+	Reservations []podReservation `json:"reservations" yaml:"reservations"`
+	Required     mesosResources   `json:"required" yaml:"required"`
+	Free         mesosResources   `json:"free" yaml:"free"`
+	Agents       int              `json:"agents" yaml:"agents"`
+	// Unplaceable are pods whose instance does not fit into the free resources of any one agent.
+	Unplaceable []string `json:"unplaceable,omitempty" yaml:"unplaceable,omitempty"`
+	Warnings    []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+}
+
+func (r *capacityReport) fits() bool {
+	headroom := r.Free.minus(r.Required)
+	return headroom.CPUs >= 0 && headroom.Mem >= 0 && headroom.Disk >= 0 && len(r.Unplaceable) == 0
+}
+
+// reservationsFromOptions works out the pod reservations from the service options.
+func reservationsFromOptions(options map[string]interface{}) ([]podReservation, error) {
+	reservations := []podReservation{}
+	for _, pod := range servicePods {
+		reservation, err := podReservationFromOptions(options, pod)
+		if err != nil {
+			return nil, err
+		}
+		if reservation != nil {
+			reservations = append(reservations, *reservation)
+		}
+	}
+	return reservations, nil
+}
+
+// podReservationFromOptions works out what one pod reserves from the service options, or nil
+// when an external service replaces the pod. A resource option that is unset and has no default
+// fails, as the pod cannot be deployed without it.
+func podReservationFromOptions(options map[string]interface{}, pod string) (*podReservation, error) {
+	paths := defaultPodResourceOptions[pod]
+	if len(paths.External) != 0 && !optionIsEmpty(options[paths.External]) {
+		return nil, nil
+	}
+	reservation := &podReservation{Pod: pod, Count: 1}
+	if len(paths.Count) != 0 {
+		count, err := optionFloat(options, paths.Count, 1)
+		if err != nil {
+			return nil, err
+		}
+		reservation.Count = int(count)
+	}
+	for _, resource := range []struct {
+		path   string
+		target *float64
+	}{{paths.CPUs, &reservation.Instance.CPUs}, {paths.Mem, &reservation.Instance.Mem}} {
+		if len(resource.path) == 0 {
+			continue
+		}
+		fallback, ok := resourceOptionDefaults[resource.path]
+		if !ok && optionIsEmpty(options[resource.path]) {
+			fix := "set it"
+			if len(paths.External) != 0 {
+				fix += fmt.Sprintf(", or set %s to use an external service", paths.External)
+			}
+			return nil, fmt.Errorf("%s is not set and has no default, so the %s pod cannot be deployed: %s", resource.path, pod, fix)
+		}
+		value, err := optionFloat(options, resource.path, fallback)
+		if err != nil {
+			return nil, err
+		}
+		*resource.target = value
+	}
+	reservation.Total = reservation.Instance.times(float64(reservation.Count))
+	return reservation, nil
+}
+
+func yamlFloat(value interface{}) float64 {
+	f, _ := strconv.ParseFloat(fmt.Sprint(value), 64)
+	return f
+}
+
+// reservationsFromSvcYML reads the pod reservations from a rendered svc.yml, counting the pods
+// which one of its plans deploys.
+func reservationsFromSvcYML(path string) ([]podReservation, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec yaml.MapSlice
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s, it must be a rendered svc.yml: %s", path, err)
+	}
+	layouts, err := loadPlanLayouts(path)
+	if err != nil {
+		return nil, err
+	}
+	deployed := map[string]bool{}
+	var collect func(layout *planLayout)
+	collect = func(layout *planLayout) {
+		deployed[layout.Pod] = true
+		for _, phase := range layout.Phases {
+			collect(phase)
+		}
+	}
+	for _, layout := range layouts {
+		collect(layout)
+	}
+
+	reservations := []podReservation{}
+	pods, _ := mapSliceGet(spec, "pods").(yaml.MapSlice)
+	for _, item := range pods {
+		name := fmt.Sprint(item.Key)
+		if len(layouts) != 0 && !deployed[name] {
+			continue
+		}
+		pod, _ := item.Value.(yaml.MapSlice)
+		reservation := podReservation{Pod: name, Count: int(yamlFloat(mapSliceGet(pod, "count")))}
+		tasks, _ := mapSliceGet(pod, "tasks").(yaml.MapSlice)
+		for _, taskItem := range tasks {
+			task, _ := taskItem.Value.(yaml.MapSlice)
+			reservation.Instance.CPUs += yamlFloat(mapSliceGet(task, "cpus"))
+			reservation.Instance.Mem += yamlFloat(mapSliceGet(task, "memory"))
+			if volume, ok := mapSliceGet(task, "volume").(yaml.MapSlice); ok {
+				reservation.Instance.Disk += yamlFloat(mapSliceGet(volume, "size"))
+			}
+			volumes, _ := mapSliceGet(task, "volumes").(yaml.MapSlice)
+			for _, volumeItem := range volumes {
+				volume, _ := volumeItem.Value.(yaml.MapSlice)
+				reservation.Instance.Disk += yamlFloat(mapSliceGet(volume, "size"))
+			}
+		}
+		reservation.Total = reservation.Instance.times(float64(reservation.Count))
+		reservations = append(reservations, reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Pod < reservations[j].Pod })
+	return reservations, nil
+}
+
+// buildCapacityReport adds the reservations up and compares them against the agents' free
+// resources.
+func buildCapacityReport(reservations []podReservation, state *mesosState) *capacityReport {
+	report := &capacityReport{Reservations: reservations}
+	report.Reservations = append(report.Reservations, podReservation{
+		Pod: "marathon-app", Count: 1, Instance: marathonAppResources, Total: marathonAppResources,
+	})
+	for _, reservation := range report.Reservations {
+		report.Required = report.Required.plus(reservation.Total)
+	}
+	agents := state.activeAgents()
+	report.Agents = len(agents)
+	for _, agent := range agents {
+		report.Free = report.Free.plus(agent.free())
+	}
+	for _, reservation := range report.Reservations {
+		placeable := false
+		for _, agent := range agents {
+			if agent.free().fits(reservation.Instance) > 0 {
+				placeable = true
+				break
+			}
+		}
+		if !placeable {
+			report.Unplaceable = append(report.Unplaceable, reservation.Pod)
+		}
+	}
+	return report
+}
+
+func formatResources(r mesosResources) []string {
+	return []string{fmt.Sprintf("%.2f", r.CPUs), fmt.Sprintf("%.0f", r.Mem), fmt.Sprintf("%.0f", r.Disk)}
+}
+
+func printCapacityReport(report *capacityReport) {
+	rows := [][]string{}
+	for _, reservation := range report.Reservations {
+		row := []string{reservation.Pod, fmt.Sprint(reservation.Count)}
+		row = append(row, formatResources(reservation.Instance)...)
+		rows = append(rows, append(row, formatResources(reservation.Total)...))
+	}
+	rows = append(rows, append([]string{"TOTAL", "", "", "", ""}, formatResources(report.Required)...))
+	rows = append(rows, append([]string{fmt.Sprintf("FREE (%d agents)", report.Agents), "", "", "", ""}, formatResources(report.Free)...))
+	printTable([]string{"POD", "COUNT", "CPUS", "MEM", "DISK", "TOTAL CPUS", "TOTAL MEM", "TOTAL DISK"}, rows)
+	for _, warning := range report.Warnings {
+		client.PrintMessage("Warning: %s", warning)
+	}
+	for _, pod := range report.Unplaceable {
+		client.PrintMessage("No agent has enough free resources for one %s instance.", pod)
+	}
+	if report.fits() {
+		headroom := report.Free.minus(report.Required)
+		client.PrintMessage("\nThe deployment fits, leaving %.2f cpus, %.0f MB mem and %.0f MB disk free.", headroom.CPUs, headroom.Mem, headroom.Disk)
+	} else {
+		client.PrintMessage("\nThe deployment does not fit into the free resources of the cluster.")
+	}
+}
+
+type capacityHandler struct {
+	optionsFile string
+	svcYML      string
+	mesosState  string
+}
+
+func (cmd *capacityHandler) handleCapacity(c *kingpin.ParseContext) error {
+	var reservations []podReservation
+	var warnings []string
+	var err error
+	if len(cmd.svcYML) != 0 {
+		reservations, err = reservationsFromSvcYML(cmd.svcYML)
+	} else {
+		var options map[string]interface{}
+		if options, err = serviceOptions(cmd.optionsFile); err == nil {
+			reservations, err = reservationsFromOptions(options)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var state *mesosState
+	if len(cmd.mesosState) != 0 {
+		state = &mesosState{}
+		if err := readJSONFile(cmd.mesosState, state); err != nil {
+			return fmt.Errorf("Failed to read Mesos state from %s: %s", cmd.mesosState, err)
+		}
+	} else if state, err = fetchMesosState(); err != nil {
+		return err
+	}
+	// the service's own tasks already hold their resources
+	if len(cmd.mesosState) == 0 && len(state.serviceTasks("")) != 0 {
+		warnings = append(warnings, fmt.Sprintf("%s is already running, the free resources exclude what it holds", config.ServiceName))
+	}
+
+	report := buildCapacityReport(reservations, state)
+	report.Warnings = warnings
+	if printed, err := printStructured(report); printed {
+		return err
+	}
+	printCapacityReport(report)
+	return nil
+}
+
+func handleCapacitySection(app *kingpin.Application) {
+	cmd := &capacityHandler{}
+	capacity := app.Command("capacity", "Sum the resources the deployment reserves and compare them with the cluster's free resources").Action(cmd.handleCapacity)
+	capacity.Flag("options", "Options file to size instead of the installed service").StringVar(&cmd.optionsFile)
+	capacity.Flag("svc-yml", "Rendered svc.yml to size instead of the options").StringVar(&cmd.svcYML)
+	capacity.Flag("mesos-state", "Mesos master state JSON file to compare against instead of the cluster's").StringVar(&cmd.mesosState)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// renderedSvcYML is src/main/dist/svc.yml as the scheduler renders it from the config.json
+// defaults, trimmed to the parts which reserve resources.
+const renderedSvcYML = `name: scale
+pods:
+  db:
+    count: 1
+    tasks:
+      launch:
+        cpus: 0.1
+        memory: 256
+  logstash:
+    count: 1
+    tasks:
+      launch:
+        cpus: 0.1
+        memory: 256
+  rabbitmq:
+    count: 1
+    tasks:
+      launch:
+        cpus: 0.5
+        memory: 512
+  scheduler:
+    count: 1
+    tasks:
+      launch:
+        cpus: 0.1
+        memory: 252
+        volume:
+  webserver:
+    count: 1
+    tasks:
+      launch:
+        cpus: 1
+        memory: 512
+        volume:
+`
+
+func TestReservationsFromDefaultOptions(t *testing.T) {
+	fromOptions, err := reservationsFromOptions(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "svc.yml")
+	if err := ioutil.WriteFile(path, []byte(renderedSvcYML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fromSvcYML, err := reservationsFromSvcYML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromOptions, fromSvcYML) {
+		t.Errorf("reservations from the default options are\n%+v\nand from the rendered svc.yml\n%+v", fromOptions, fromSvcYML)
+	}
+}
+
+func TestReservationsSkipExternalServices(t *testing.T) {
+	reservations, err := reservationsFromOptions(map[string]interface{}{
+		"db.db-host":           "db.example",
+		"messaging.broker-url": "amqp://broker.example//",
+		"node.count":           3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pods := map[string]int{}
+	for _, reservation := range reservations {
+		pods[reservation.Pod] = reservation.Count
+	}
+	expected := map[string]int{"logstash": 1, "scheduler": 3, "webserver": 1}
+	if !reflect.DeepEqual(pods, expected) {
+		t.Errorf("reserved pods are %v, expected %v", pods, expected)
+	}
+}
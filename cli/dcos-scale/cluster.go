@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+)
+
+// httpError is returned for any response outside of the 2xx range so that callers can decide
+// whether a status is fatal (eg a 404 when probing for an optional object).
+type httpError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%s %s returned %d: %s", e.Method, e.URL, e.StatusCode, strings.TrimSpace(string(e.Body)))
+}
+
+func isStatus(err error, statusCode int) bool {
+	httpErr, ok := err.(*httpError)
+	return ok && httpErr.StatusCode == statusCode
+}
+
+func dcosURL() string {
+	if len(config.DcosUrl) == 0 {
+		config.DcosUrl = client.RequiredCLIConfigValue(
+			"core.dcos_url",
+			"DC/OS Cluster URL",
+			"Run 'dcos config set core.dcos_url http://your-cluster.com' to configure.")
+	}
+	return strings.TrimRight(config.DcosUrl, "/")
+}
+
+// dcosAuthToken returns the token of the profile in effect, or else that of the DC/OS CLI.
+func dcosAuthToken() (string, error) {
+	if err := resolvePendingToken(); err != nil {
+		return "", err
+	}
+	if len(config.DcosAuthToken) == 0 {
+		config.DcosAuthToken = client.OptionalCLIConfigValue("core.dcos_acs_token")
+	}
+	return config.DcosAuthToken, nil
+}
+
+func httpClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.TlsForceInsecure},
+		},
+	}
+}
+
+// doRequest performs a request against an absolute URL, authenticating with the DC/OS token when
+// one is configured, and returns the response body.
+func doRequest(method, url string, payload []byte, contentType string) ([]byte, error) {
+	headers := map[string]string{}
+	if len(contentType) != 0 {
+		headers["Content-Type"] = contentType
+	}
+	return doRequestHeaders(method, url, payload, headers)
+}
+
+func doRequestHeaders(method, url string, payload []byte, headers map[string]string) ([]byte, error) {
+	request, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	token, err := dcosAuthToken()
+	if err != nil {
+		return nil, err
+	}
+	if len(token) != 0 {
+		request.Header.Set("Authorization", "token="+token)
+	}
+	if config.Verbose {
+		client.PrintMessage("HTTP Query: %s %s", method, url)
+	}
+	response, err := httpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return body, &httpError{Method: method, URL: url, StatusCode: response.StatusCode, Body: body}
+	}
+	return body, nil
+}
+
+// clusterRequest performs a request against a path on the DC/OS cluster, eg "secrets/v1/...".
+func clusterRequest(method, urlPath string, payload []byte, contentType string) ([]byte, error) {
+	return doRequest(method, dcosURL()+"/"+strings.TrimLeft(urlPath, "/"), payload, contentType)
+}
+
+func clusterGetJSON(urlPath string, target interface{}) error {
+	body, err := clusterRequest("GET", urlPath, nil, "")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, target)
+}
+
+// serviceRequest performs a request against the service scheduler through the admin router.
+func serviceRequest(method, urlPath string, payload []byte, contentType string) ([]byte, error) {
+	return clusterRequest(method, fmt.Sprintf("service/%s/%s", strings.Trim(config.ServiceName, "/"), strings.TrimLeft(urlPath, "/")), payload, contentType)
+}
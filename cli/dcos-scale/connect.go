@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// endpoints connect prints ready-to-use connection strings for the supporting services and the
+// Scale API. The SDK's endpoints command takes a single endpoint name and kingpin does not allow
+// subcommands next to it, so connect is picked up as that name, with the service as a second
+// argument, before the SDK lists endpoints.
+
+var (
+	connectTargets = []string{"db", "broker", "logstash", "webserver"}
+	connectFormats = []string{"env", "url", "kombu", "django"}
+)
+
+// sdkEndpoint is an endpoint as listed by the scheduler under v1/endpoints/<name>.
+type sdkEndpoint struct {
+	Address []string `json:"address"`
+	DNS     []string `json:"dns"`
+	VIP     string   `json:"vip"`
+	VIPs    []string `json:"vips"`
+}
+
+func fetchEndpoint(name string) (*sdkEndpoint, error) {
+	body, err := serviceRequest("GET", "v1/endpoints/"+name, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var endpoint sdkEndpoint
+	if err := json.Unmarshal(body, &endpoint); err != nil {
+		return nil, fmt.Errorf("Failed to parse endpoint %s: %s", name, err)
+	}
+	if len(endpoint.VIP) == 0 && len(endpoint.VIPs) != 0 {
+		endpoint.VIP = endpoint.VIPs[0]
+	}
+	return &endpoint, nil
+}
+
+// connectionVar is one variable of a connection, eg DATABASE_URL.
+type connectionVar struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// connection holds the variables for connecting to a target, the primary one first.
+type connection struct {
+	Target   string          `json:"target" yaml:"target"`
+	External bool            `json:"external" yaml:"external"`
+	URL      string          `json:"url" yaml:"url"`
+	Vars     []connectionVar `json:"vars" yaml:"vars"`
+	// Kombu is the broker URL in the form Kombu expects, which writes the default vhost as "//".
+	Kombu string `json:"-" yaml:"-"`
+	// Django holds the settings of a Django DATABASES entry.
+	Django [][2]string `json:"-" yaml:"-"`
+}
+
+type connectHandler struct {
+	name        string
+	target      string
+	format      string
+	optionsFile string
+	useIP       bool
+}
+
+// serviceEndpointAddress returns the host:port of a port of the service, from the SDK endpoint when
+// it is advertised or else from the task's autoip name.
+func serviceEndpointAddress(endpointName, pod string, port int, useIP bool) string {
+	endpoint, err := fetchEndpoint(endpointName)
+	if err == nil {
+		if useIP && len(endpoint.Address) != 0 {
+			return endpoint.Address[0]
+		}
+		if !useIP && len(endpoint.DNS) != 0 {
+			return endpoint.DNS[0]
+		}
+	}
+	return taskAddress(pod, "launch", port)
+}
+
+func (cmd *connectHandler) endpointAddress(endpointName, pod string, port int) string {
+	return serviceEndpointAddress(endpointName, pod, port, cmd.useIP)
+}
+
+func splitAddress(address, defaultPort string) (string, string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, defaultPort
+	}
+	return host, port
+}
+
+func (cmd *connectHandler) dbConnection(options map[string]interface{}) (*connection, error) {
+	password, err := secretOptionValue(newSecretStore(), options, "db-pass")
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		password = "scale"
+	}
+	user := optionString(options, "db.db-user", "scale")
+	name := optionString(options, "db.db-name", "scale")
+	conn := &connection{Target: "db"}
+	var host, port string
+	if external := optionString(options, "db.db-host", ""); len(external) != 0 {
+		host, port = external, optionString(options, "db.db-port", fmt.Sprint(dbPort))
+		conn.External = true
+	} else {
+		host, port = splitAddress(cmd.endpointAddress("db", "db", dbPort), fmt.Sprint(dbPort))
+	}
+	databaseURL := url.URL{Scheme: "postgis", User: url.UserPassword(user, password), Host: net.JoinHostPort(host, port), Path: "/" + name}
+	conn.URL = databaseURL.String()
+	conn.Vars = []connectionVar{{Name: "DATABASE_URL", Value: conn.URL}}
+	conn.Django = [][2]string{
+		{"ENGINE", "django.contrib.gis.db.backends.postgis"},
+		{"NAME", name},
+		{"USER", user},
+		{"PASSWORD", password},
+		{"HOST", host},
+		{"PORT", port},
+	}
+	return conn, nil
+}
+
+func (cmd *connectHandler) brokerConnection(options map[string]interface{}) (*connection, error) {
+	brokerURL, err := secretOptionValue(newSecretStore(), options, "broker-url")
+	if err != nil {
+		return nil, err
+	}
+	if len(brokerURL) != 0 {
+		return &connection{Target: "broker", External: true, URL: brokerURL, Vars: []connectionVar{{Name: "BROKER_URL", Value: brokerURL}}, Kombu: brokerURL}, nil
+	}
+	address := cmd.endpointAddress("broker", "rabbitmq", brokerPort)
+	// the rabbitmq pod has only the default vhost, which scale-deploy hands the scheduler; the
+	// AMQP URI spec escapes it, Kombu takes everything after the first slash as is
+	base := url.URL{Scheme: "amqp", User: url.UserPassword("guest", "guest"), Host: address}
+	kombu := base.String() + "//"
+	return &connection{
+		Target: "broker",
+		URL:    base.String() + "/%2F",
+		Vars:   []connectionVar{{Name: "BROKER_URL", Value: kombu}},
+		Kombu:  kombu,
+	}, nil
+}
+
+func (cmd *connectHandler) logstashConnection(options map[string]interface{}) (*connection, error) {
+	if address := optionString(options, "logging.logstash-address", ""); len(address) != 0 {
+		return &connection{Target: "logstash", External: true, URL: address, Vars: []connectionVar{{Name: "LOGGING_ADDRESS", Value: address}}}, nil
+	}
+	conn := &connection{Target: "logstash", URL: "tcp://" + cmd.endpointAddress("logging", "logstash", logstashLoggingPort)}
+	conn.Vars = []connectionVar{{Name: "LOGGING_ADDRESS", Value: conn.URL}}
+	// the health check port is load balanced under the logstash VIP prefix
+	vip := vipAddress("logstash", logstashHealthCheckVIP)
+	if endpoint, err := fetchEndpoint("health-check"); err == nil && len(endpoint.VIP) != 0 {
+		vip = endpoint.VIP
+	}
+	conn.Vars = append(conn.Vars, connectionVar{Name: "LOGSTASH_HEALTH_URL", Value: "http://" + vip})
+	return conn, nil
+}
+
+func (cmd *connectHandler) webserverConnection(options map[string]interface{}) (*connection, error) {
+	adapter, err := scaleAPI()
+	if err != nil {
+		return nil, err
+	}
+	url, err := scaleAPIURL("")
+	if err != nil {
+		return nil, err
+	}
+	conn := &connection{Target: "webserver", External: true, URL: url}
+	conn.Vars = []connectionVar{{Name: "SCALE_API_URL", Value: conn.URL}}
+	// inside the cluster the service's API port is reachable under the api.<service> VIP that
+	// marathon.json.mustache labels it with, which the admin router path maps onto
+	servicePath := "/service/" + strings.Trim(config.ServiceName, "/")
+	if basePath := scaleAPIBasePath(); strings.HasPrefix(basePath, servicePath) {
+		vip := fmt.Sprintf("api.%s.marathon.l4lb.thisdcos.directory", strings.Trim(config.ServiceName, "/"))
+		internal := fmt.Sprintf("http://%s%s/%s/", vip, strings.TrimPrefix(basePath, servicePath), adapter.Version)
+		conn.Vars = append(conn.Vars, connectionVar{Name: "SCALE_API_INTERNAL_URL", Value: internal})
+	}
+	return conn, nil
+}
+
+// shellQuote quotes a value for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// pythonString quotes a value as a Python string literal.
+func pythonString(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+func formatConnection(conn *connection, format string) (string, error) {
+	switch format {
+	case "env":
+		lines := []string{}
+		for _, v := range conn.Vars {
+			lines = append(lines, v.Name+"="+shellQuote(v.Value))
+		}
+		return strings.Join(lines, "\n"), nil
+	case "url":
+		return conn.URL, nil
+	case "kombu":
+		if len(conn.Kombu) == 0 {
+			return "", fmt.Errorf("The kombu format only applies to the broker")
+		}
+		return conn.Kombu, nil
+	case "django":
+		if len(conn.Kombu) != 0 {
+			return "BROKER_URL = " + pythonString(conn.Kombu), nil
+		}
+		if len(conn.Django) == 0 {
+			return "", fmt.Errorf("The django format only applies to the db and broker")
+		}
+		lines := []string{"DATABASES = {", "    'default': {"}
+		for _, setting := range conn.Django {
+			lines = append(lines, fmt.Sprintf("        %s: %s,", pythonString(setting[0]), pythonString(setting[1])))
+		}
+		return strings.Join(append(lines, "    }", "}"), "\n"), nil
+	}
+	return "", fmt.Errorf("Unknown format %s", format)
+}
+
+func (cmd *connectHandler) handleConnect(c *kingpin.ParseContext) error {
+	if cmd.name != "connect" {
+		return nil
+	}
+	if !containsString(connectTargets, cmd.target) {
+		return fmt.Errorf("Usage: endpoints connect <%s> [--format %s]", strings.Join(connectTargets, "|"), strings.Join(connectFormats, "|"))
+	}
+	options, err := serviceOptions(cmd.optionsFile)
+	if err != nil {
+		return err
+	}
+	builders := map[string]func(map[string]interface{}) (*connection, error){
+		"db":        cmd.dbConnection,
+		"broker":    cmd.brokerConnection,
+		"logstash":  cmd.logstashConnection,
+		"webserver": cmd.webserverConnection,
+	}
+	conn, err := builders[cmd.target](options)
+	if err != nil {
+		return err
+	}
+	if printed, err := printStructured(conn); printed {
+		if err != nil {
+			return err
+		}
+	} else {
+		out, err := formatConnection(conn, cmd.format)
+		if err != nil {
+			return err
+		}
+		fmt.Println(out)
+	}
+	// connect is not an endpoint, so the SDK's own endpoints action must not run
+	os.Exit(0)
+	return nil
+}
+
+func handleEndpointsConnectSection(app *kingpin.Application) {
+	endpoints := app.GetCommand("endpoints")
+	if endpoints == nil {
+		return
+	}
+	cmd := &connectHandler{}
+	endpoints.PreAction(func(c *kingpin.ParseContext) error {
+		cmd.name = argValue(c, "name")
+		return cmd.handleConnect(c)
+	})
+	endpoints.Arg("service", fmt.Sprintf("With 'connect' as the name: the service to print a connection string for (%s)", strings.Join(connectTargets, ", "))).StringVar(&cmd.target)
+	endpoints.Flag("format", "With 'connect': format to print the connection in").Default("env").EnumVar(&cmd.format, connectFormats...)
+	endpoints.Flag("options", "With 'connect': options file to take external addresses and credentials from instead of the installed service").StringVar(&cmd.optionsFile)
+	endpoints.Flag("ip", "With 'connect': use agent IP addresses instead of DNS names").BoolVar(&cmd.useIP)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Scale stores its data through GeoDjango, so an external database given with db.db-host must run
+// a Postgres and a PostGIS release Django supports, have the postgis extension created in
+// db.db-name, and let db.db-user create the tables of Scale's migrations.
+
+const (
+	// minimumPostgresVersion is the oldest Postgres Django supports, in server_version_num form.
+	minimumPostgresVersion = 90300
+	minimumPostGISVersion  = "2.0"
+)
+
+// dbCheck is the outcome of one database check, with what to do when it failed.
+type dbCheck struct {
+	Check  string `json:"check" yaml:"check"`
+	OK     bool   `json:"ok" yaml:"ok"`
+	Detail string `json:"detail" yaml:"detail"`
+	Fix    string `json:"fix,omitempty" yaml:"fix,omitempty"`
+}
+
+// dbConnectFix explains a failed login in terms of the options to change.
+func dbConnectFix(err error, config pgConfig) string {
+	pgErr, ok := err.(*pgError)
+	if !ok {
+		if _, ok := err.(net.Error); ok {
+			return fmt.Sprintf("Check db.db-host and db.db-port, and that %s accepts connections from the DC/OS agents", config.Address)
+		}
+		return "Check that db.db-host and db.db-port point at a Postgres server which accepts plaintext connections"
+	}
+	switch pgErr.Code {
+	case "28P01":
+		return fmt.Sprintf("The password of %s is wrong: fix db.db-pass or the secret of db.db-pass-secret", config.User)
+	case "28000":
+		return fmt.Sprintf("Add a pg_hba.conf entry allowing %s to reach database %s from the DC/OS agents, eg 'host %s %s 0.0.0.0/0 md5', and reload the server",
+			config.User, config.Database, config.Database, config.User)
+	case "3D000":
+		return fmt.Sprintf("Create the database: CREATE DATABASE %s OWNER %s;", config.Database, config.User)
+	case "53300":
+		return "The server has no free connections: raise max_connections or stop idle clients"
+	}
+	return "See the server's error above"
+}
+
+// comparePostGISVersions compares dotted versions such as 2.4.3, ignoring suffixes such as dev.
+func comparePostGISVersions(a, b string) int {
+	left, right := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(left) || i < len(right); i++ {
+		var x, y int
+		if i < len(left) {
+			x, _ = strconv.Atoi(strings.TrimRight(left[i], "abcdefghijklmnopqrstuvwxyz"))
+		}
+		if i < len(right) {
+			y, _ = strconv.Atoi(strings.TrimRight(right[i], "abcdefghijklmnopqrstuvwxyz"))
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// queryValue returns the first column of the first row, or the empty string when there is none.
+func queryValue(conn *pgConn, sql string) (string, error) {
+	rows, err := conn.query(sql)
+	if err != nil || len(rows) == 0 || len(rows[0]) == 0 {
+		return "", err
+	}
+	return rows[0][0], nil
+}
+
+func checkServerVersion(conn *pgConn) dbCheck {
+	check := dbCheck{Check: "version"}
+	number, err := queryValue(conn, "SHOW server_version_num")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	version, _ := strconv.Atoi(number)
+	check.Detail = "Postgres " + conn.Params["server_version"]
+	if version < minimumPostgresVersion {
+		check.Fix = fmt.Sprintf("Scale needs Postgres %d.%d or later: upgrade the server", minimumPostgresVersion/10000, minimumPostgresVersion/100%100)
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func checkPostGIS(conn *pgConn, database string) dbCheck {
+	check := dbCheck{Check: "postgis"}
+	installed, err := queryValue(conn, "SELECT extversion FROM pg_extension WHERE extname = 'postgis'")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if len(installed) == 0 {
+		available, err := queryValue(conn, "SELECT default_version FROM pg_available_extensions WHERE name = 'postgis'")
+		if err != nil {
+			check.Detail = err.Error()
+			return check
+		}
+		if len(available) == 0 {
+			check.Detail = "PostGIS is not installed on the server"
+			check.Fix = fmt.Sprintf("Install the PostGIS package matching Postgres %s on the server, then connect to %s as a superuser and run: CREATE EXTENSION postgis;",
+				conn.Params["server_version"], database)
+			return check
+		}
+		check.Detail = fmt.Sprintf("PostGIS %s is available but the extension is not created in %s", available, database)
+		check.Fix = fmt.Sprintf("Connect to %s as a superuser and run: CREATE EXTENSION postgis;", database)
+		return check
+	}
+	check.Detail = "PostGIS " + installed
+	if comparePostGISVersions(installed, minimumPostGISVersion) < 0 {
+		check.Fix = fmt.Sprintf("Scale needs PostGIS %s or later: upgrade it and run ALTER EXTENSION postgis UPDATE;", minimumPostGISVersion)
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// checkCreatePrivilege checks that the user can create the tables of Scale's migrations, which go
+// to the public schema.
+func checkCreatePrivilege(conn *pgConn, user string) dbCheck {
+	check := dbCheck{Check: "privileges"}
+	allowed, err := queryValue(conn, "SELECT has_schema_privilege(current_user, 'public', 'CREATE')")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if allowed != "t" {
+		check.Detail = fmt.Sprintf("%s cannot create tables in schema public", user)
+		check.Fix = fmt.Sprintf("Run as the database owner or a superuser: GRANT CREATE ON SCHEMA public TO %s;", user)
+		return check
+	}
+	check.OK = true
+	check.Detail = fmt.Sprintf("%s can create tables in schema public", user)
+	return check
+}
+
+type dbHandler struct {
+	optionsFile   string
+	host          string
+	port          int
+	name          string
+	user          string
+	passwordStdin bool
+	timeout       time.Duration
+}
+
+// config returns the connection settings, taking those not given as flags from the options. With
+// --host and no options file the service need not be installed yet, so the database can be checked
+// before it is entered in db.db-host.
+func (cmd *dbHandler) config() (pgConfig, error) {
+	options := map[string]interface{}{}
+	var err error
+	if len(cmd.host) == 0 || len(cmd.optionsFile) != 0 {
+		if options, err = serviceOptions(cmd.optionsFile); err != nil {
+			return pgConfig{}, err
+		}
+	}
+	config := pgConfig{
+		User:     optionString(options, "db.db-user", "scale"),
+		Database: optionString(options, "db.db-name", "scale"),
+		Timeout:  cmd.timeout,
+	}
+	if len(cmd.user) != 0 {
+		config.User = cmd.user
+	}
+	if len(cmd.name) != 0 {
+		config.Database = cmd.name
+	}
+	host := cmd.host
+	if len(host) == 0 {
+		host = optionString(options, "db.db-host", "")
+	}
+	if len(host) == 0 {
+		return pgConfig{}, fmt.Errorf("db.db-host is empty, so Scale deploys its sample database: give the external database with --host")
+	}
+	port := optionString(options, "db.db-port", fmt.Sprint(dbPort))
+	if cmd.port != 0 {
+		port = fmt.Sprint(cmd.port)
+	}
+	config.Address = net.JoinHostPort(host, port)
+	if cmd.passwordStdin {
+		config.Password, err = readSecretValue(os.Stdin)
+	} else {
+		config.Password, err = secretOptionValue(newSecretStore(), options, "db-pass")
+		if len(config.Password) == 0 {
+			config.Password = "scale"
+		}
+	}
+	return config, err
+}
+
+func (cmd *dbHandler) handleCheck(c *kingpin.ParseContext) error {
+	config, err := cmd.config()
+	if err != nil {
+		return err
+	}
+	checks := []dbCheck{}
+	login := dbCheck{Check: "login"}
+	conn, err := pgConnect(config)
+	if err != nil {
+		login.Detail, login.Fix = err.Error(), dbConnectFix(err, config)
+		checks = append(checks, login)
+	} else {
+		defer conn.Close()
+		login.OK = true
+		login.Detail = fmt.Sprintf("%s logged in to %s at %s", config.User, config.Database, config.Address)
+		checks = append(checks, login, checkServerVersion(conn), checkPostGIS(conn, config.Database), checkCreatePrivilege(conn, config.User))
+	}
+	failed := 0
+	for _, check := range checks {
+		if !check.OK {
+			failed++
+		}
+	}
+	if printed, err := printStructured(checks); !printed {
+		rows := [][]string{}
+		for _, check := range checks {
+			status := "OK"
+			if !check.OK {
+				status = "FAIL"
+			}
+			rows = append(rows, []string{check.Check, status, check.Detail})
+		}
+		printTable([]string{"CHECK", "STATUS", "DETAIL"}, rows)
+		for _, check := range checks {
+			if len(check.Fix) != 0 {
+				client.PrintMessage("\nTo fix %s: %s", check.Check, check.Fix)
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	if failed != 0 {
+		return fmt.Errorf("The database failed %d of %d checks", failed, len(checks))
+	}
+	return nil
+}
+
+func handleDBSection(app *kingpin.Application) {
+	cmd := &dbHandler{}
+	db := app.Command("db", "Check an external Scale database")
+
+	check := db.Command("check", "Check that the database accepts Scale's login, has PostGIS and lets Scale create its tables").Action(cmd.handleCheck)
+	check.Flag("options", "Options file to take the database settings from instead of the installed service").StringVar(&cmd.optionsFile)
+	check.Flag("host", "Database host, defaults to db.db-host").StringVar(&cmd.host)
+	check.Flag("port", "Database port, defaults to db.db-port").IntVar(&cmd.port)
+	check.Flag("name", "Database name, defaults to db.db-name").StringVar(&cmd.name)
+	check.Flag("user", "Database user, defaults to db.db-user").StringVar(&cmd.user)
+	check.Flag("password-stdin", "Read the password from stdin instead of db.db-pass").BoolVar(&cmd.passwordStdin)
+	check.Flag("timeout", "Timeout for the connection and its queries").Default("10s").DurationVar(&cmd.timeout)
+}
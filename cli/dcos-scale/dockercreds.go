@@ -0,0 +1,315 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// scale.docker-credentials is a URI the Mesos fetcher downloads into each task's sandbox and
+// extracts there. Docker reads credentials from $HOME/.docker/config.json, falling back to
+// $HOME/.dockercfg, and the sandbox is $HOME, so the archive must hold .docker/config.json at its
+// root, as 'tar -czf docker.tar.gz .docker' run from the home directory produces.
+
+const (
+	dockerConfigEntry = ".docker/config.json"
+	legacyDockercfg   = ".dockercfg"
+	// dockerHubRegistry is the key Docker stores Docker Hub credentials under.
+	dockerHubRegistry = "https://index.docker.io/v1/"
+)
+
+// credentialImageAssets are the docker image assets whose registries the credentials must cover.
+var credentialImageAssets = []string{"db", "logstash", "rabbitmq", "scale"}
+
+type dockerAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+	// CredsStore and CredHelpers name helper binaries, which do not exist on the agents.
+	CredsStore  string            `json:"credsStore,omitempty"`
+	CredHelpers map[string]string `json:"credHelpers,omitempty"`
+}
+
+// normalizeRegistry reduces a registry as written in an image, a config key or a flag to the host
+// Docker pulls from, with Docker Hub's aliases folded into docker.io.
+func normalizeRegistry(registry string) string {
+	registry = strings.ToLower(strings.TrimSpace(registry))
+	if i := strings.Index(registry, "://"); i >= 0 {
+		registry = registry[i+3:]
+	}
+	if i := strings.Index(registry, "/"); i >= 0 {
+		registry = registry[:i]
+	}
+	switch registry {
+	case "docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return registry
+}
+
+// imageRegistry returns the registry an image reference pulls from: its first component when that
+// looks like a host, else Docker Hub.
+func imageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return "docker.io"
+	}
+	first := image[:i]
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return normalizeRegistry(first)
+	}
+	return "docker.io"
+}
+
+// registryKey returns the key Docker stores a registry's credentials under.
+func registryKey(registry string) string {
+	if normalizeRegistry(registry) == "docker.io" {
+		return dockerHubRegistry
+	}
+	return normalizeRegistry(registry)
+}
+
+// authProblem returns why Docker cannot log in with an entry, or the empty string.
+func authProblem(auth dockerAuth) string {
+	if len(auth.Auth) == 0 {
+		if len(auth.Username) != 0 && len(auth.Password) != 0 {
+			return ""
+		}
+		return "no credentials, the auth field is empty"
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "auth is not base64"
+	}
+	if parts := strings.SplitN(string(decoded), ":", 2); len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "auth must encode user:password"
+	}
+	return ""
+}
+
+// writeDockerCredentials writes a tar.gz holding .docker/config.json.
+func writeDockerCredentials(w io.Writer, config *dockerConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	if err := archive.WriteHeader(&tar.Header{Name: path.Dir(dockerConfigEntry) + "/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: now}); err != nil {
+		return err
+	}
+	if err := archive.WriteHeader(&tar.Header{Name: dockerConfigEntry, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data)), ModTime: now}); err != nil {
+		return err
+	}
+	if _, err := archive.Write(data); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readDockerCredentials returns the credentials of an archive and the entry they were read from,
+// .docker/config.json or else the legacy .dockercfg.
+func readDockerCredentials(r io.Reader) (*dockerConfig, string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("not a gzip archive: %s", err)
+	}
+	archive := tar.NewReader(gz)
+	entries := map[string][]byte{}
+	names := []string{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("not a tar archive: %s", err)
+		}
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		names = append(names, name)
+		if header.Typeflag == tar.TypeReg && (name == dockerConfigEntry || name == legacyDockercfg) {
+			if entries[name], err = ioutil.ReadAll(archive); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if data, ok := entries[dockerConfigEntry]; ok {
+		config := &dockerConfig{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, "", fmt.Errorf("%s is not valid JSON: %s", dockerConfigEntry, err)
+		}
+		return config, dockerConfigEntry, nil
+	}
+	if data, ok := entries[legacyDockercfg]; ok {
+		config := &dockerConfig{}
+		if err := json.Unmarshal(data, &config.Auths); err != nil {
+			return nil, "", fmt.Errorf("%s is not valid JSON: %s", legacyDockercfg, err)
+		}
+		return config, legacyDockercfg, nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if path.Base(name) == "config.json" || path.Base(name) == legacyDockercfg {
+			return nil, "", fmt.Errorf("the archive holds %s, but the credentials must be at %s relative to its root", name, dockerConfigEntry)
+		}
+	}
+	return nil, "", fmt.Errorf("the archive holds no %s", dockerConfigEntry)
+}
+
+// imageCoverage tells whether the credentials cover the registry of one image asset.
+type imageCoverage struct {
+	Asset    string `json:"asset" yaml:"asset"`
+	Image    string `json:"image" yaml:"image"`
+	Registry string `json:"registry" yaml:"registry"`
+	Covered  bool   `json:"covered" yaml:"covered"`
+}
+
+type dockerCredentialsReport struct {
+	File     string          `json:"file" yaml:"file"`
+	Entry    string          `json:"entry" yaml:"entry"`
+	Images   []imageCoverage `json:"images" yaml:"images"`
+	Problems []string        `json:"problems" yaml:"problems"`
+}
+
+type dockerCredentialsHandler struct {
+	registries    []string
+	user          string
+	passwordStdin bool
+	out           string
+	file          string
+	packageName   string
+	version       string
+	repoDir       string
+}
+
+func (cmd *dockerCredentialsHandler) handleBuild(c *kingpin.ParseContext) error {
+	if !cmd.passwordStdin {
+		return fmt.Errorf("Pass the password with --password-stdin, so it does not end up in the shell history")
+	}
+	password, err := readSecretValue(os.Stdin)
+	if err != nil {
+		return err
+	}
+	auth := dockerAuth{Auth: base64.StdEncoding.EncodeToString([]byte(cmd.user + ":" + password))}
+	config := &dockerConfig{Auths: map[string]dockerAuth{}}
+	for _, registry := range cmd.registries {
+		config.Auths[registryKey(registry)] = auth
+	}
+	var buf bytes.Buffer
+	if err := writeDockerCredentials(&buf, config); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(cmd.out, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	client.PrintMessage("Wrote %s with credentials of %s for %s.", cmd.out, cmd.user, strings.Join(cmd.registries, ", "))
+	client.PrintMessage("Upload it where the agents can fetch it and set scale.docker-credentials to its URI.")
+	return nil
+}
+
+func (cmd *dockerCredentialsHandler) handleVerify(c *kingpin.ParseContext) error {
+	file, err := os.Open(cmd.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	config, entry, err := readDockerCredentials(file)
+	if err != nil {
+		return fmt.Errorf("%s cannot be used as scale.docker-credentials: %s", cmd.file, err)
+	}
+	report := &dockerCredentialsReport{File: cmd.file, Entry: entry, Images: []imageCoverage{}, Problems: []string{}}
+	if entry == legacyDockercfg {
+		report.Problems = append(report.Problems, fmt.Sprintf("the archive uses the legacy %s, which newer Docker releases ignore: rebuild it with 'docker-credentials build'", legacyDockercfg))
+	}
+	if len(config.CredsStore) != 0 || len(config.CredHelpers) != 0 {
+		report.Problems = append(report.Problems, "the config names credential helpers, which do not exist on the agents: rebuild it with 'docker-credentials build'")
+	}
+	keys := []string{}
+	for key := range config.Auths {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	covered := map[string]bool{}
+	for _, key := range keys {
+		if problem := authProblem(config.Auths[key]); len(problem) != 0 {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %s", key, problem))
+			continue
+		}
+		covered[normalizeRegistry(key)] = true
+	}
+
+	pkg, err := loadPackaging(cmd.packageName, cmd.repoDir, cmd.version)
+	if err != nil {
+		return err
+	}
+	images := dockerImages(pkg.Resource)
+	for _, asset := range credentialImageAssets {
+		image, ok := images[asset]
+		if !ok {
+			report.Problems = append(report.Problems, fmt.Sprintf("resource.json of %s has no docker image asset %s", pkg.Version, asset))
+			continue
+		}
+		coverage := imageCoverage{Asset: asset, Image: image, Registry: imageRegistry(image)}
+		coverage.Covered = covered[coverage.Registry]
+		if !coverage.Covered {
+			report.Problems = append(report.Problems, fmt.Sprintf("no credentials for %s, which image %s is pulled from", coverage.Registry, image))
+		}
+		report.Images = append(report.Images, coverage)
+	}
+
+	if printed, err := printStructured(report); !printed {
+		rows := [][]string{}
+		for _, coverage := range report.Images {
+			rows = append(rows, []string{coverage.Asset, coverage.Image, coverage.Registry, fmt.Sprint(coverage.Covered)})
+		}
+		printTable([]string{"ASSET", "IMAGE", "REGISTRY", "COVERED"}, rows)
+		for _, problem := range report.Problems {
+			client.PrintMessage("\nERROR: %s", problem)
+		}
+	} else if err != nil {
+		return err
+	}
+	if len(report.Problems) != 0 {
+		return fmt.Errorf("%s failed verification with %d problems", cmd.file, len(report.Problems))
+	}
+	return nil
+}
+
+func handleDockerCredentialsSection(app *kingpin.Application) {
+	cmd := &dockerCredentialsHandler{}
+	credentials := app.Command("docker-credentials", "Build and verify the archive of scale.docker-credentials")
+
+	build := credentials.Command("build", "Build a credentials archive in the layout the Mesos fetcher extracts").Action(cmd.handleBuild)
+	build.Flag("registry", "Registry to log in to, eg registry.example.com:5000 or docker.io, can be repeated").Required().StringsVar(&cmd.registries)
+	build.Flag("user", "User to log in as").Required().StringVar(&cmd.user)
+	build.Flag("password-stdin", "Read the password from stdin").BoolVar(&cmd.passwordStdin)
+	build.Flag("out", "Archive to write").Default("docker.tar.gz").StringVar(&cmd.out)
+
+	verify := credentials.Command("verify", "Check that an archive covers the registries of Scale's images").Action(cmd.handleVerify)
+	verify.Arg("file", "Archive to verify").Required().StringVar(&cmd.file)
+	verify.Flag("version", "Package version whose images to check, or a directory holding its packaging files").Required().StringVar(&cmd.version)
+	verify.Flag("package-name", "Name of the package in the package repository").Default("scale").StringVar(&cmd.packageName)
+	verify.Flag("repo-dir", "Directory with one packaging directory per version, read instead of the package repository").StringVar(&cmd.repoDir)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/config"
+)
+
+// A small client for the Elasticsearch REST API, for checking the cluster Scale's logstash writes
+// to. Credentials are taken from the URL, as logstash takes them.
+
+type elasticsearchNode struct {
+	URL     *url.URL
+	Timeout time.Duration
+}
+
+// parseElasticsearchURLs parses the comma separated URLs of logging.elasticsearch-urls.
+func parseElasticsearchURLs(value string, timeout time.Duration) ([]*elasticsearchNode, error) {
+	nodes := []*elasticsearchNode{}
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil || len(parsed.Host) == 0 || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("Invalid Elasticsearch URL '%s': expected http(s)://host:port", maskedURL(parsed, raw))
+		}
+		nodes = append(nodes, &elasticsearchNode{URL: parsed, Timeout: timeout})
+	}
+	return nodes, nil
+}
+
+// maskedURL returns a URL with its password replaced, for printing, or fallback when it did not
+// parse.
+func maskedURL(u *url.URL, fallback string) string {
+	if u == nil {
+		if i := strings.LastIndex(fallback, "@"); i >= 0 {
+			return "****" + fallback[i:]
+		}
+		return fallback
+	}
+	if _, ok := u.User.Password(); ok {
+		masked := *u
+		masked.User = nil
+		return strings.Replace(masked.String(), "://", "://"+url.PathEscape(u.User.Username())+":****@", 1)
+	}
+	return u.String()
+}
+
+func (n *elasticsearchNode) String() string {
+	return maskedURL(n.URL, "")
+}
+
+func (n *elasticsearchNode) request(method, path string, body []byte) ([]byte, error) {
+	target := *n.URL
+	target.Path = strings.TrimRight(target.Path, "/") + "/" + strings.TrimLeft(path, "/")
+	if i := strings.Index(target.Path, "?"); i >= 0 {
+		target.RawQuery = target.Path[i+1:]
+		target.Path = target.Path[:i]
+	}
+	request, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	httpClient := &http.Client{
+		Timeout: n.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.TlsForceInsecure},
+		},
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		// the error quotes the URL, with its credentials
+		if urlErr, ok := err.(*url.Error); ok {
+			return nil, urlErr.Err
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		target.User = nil
+		return data, &httpError{Method: method, URL: target.String(), StatusCode: response.StatusCode, Body: data}
+	}
+	return data, nil
+}
+
+func (n *elasticsearchNode) get(path string, target interface{}) error {
+	data, err := n.request("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("Failed to parse Elasticsearch response for %s: %s", path, err)
+	}
+	return nil
+}
+
+type elasticsearchInfo struct {
+	Name        string `json:"name"`
+	ClusterName string `json:"cluster_name"`
+	ClusterUUID string `json:"cluster_uuid"`
+	Version     struct {
+		Number string `json:"number"`
+	} `json:"version"`
+}
+
+type elasticsearchHealth struct {
+	Status        string `json:"status"`
+	NumberOfNodes int    `json:"number_of_nodes"`
+}
+
+type elasticsearchIndex struct {
+	Index     string `json:"index"`
+	Health    string `json:"health"`
+	DocsCount string `json:"docs.count"`
+}
+
+func (n *elasticsearchNode) info() (*elasticsearchInfo, error) {
+	var info elasticsearchInfo
+	if err := n.get("", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (n *elasticsearchNode) health() (*elasticsearchHealth, error) {
+	var health elasticsearchHealth
+	if err := n.get("_cluster/health", &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// indices lists the indices matching a pattern, eg scalelogs-*.
+func (n *elasticsearchNode) indices(pattern string) ([]elasticsearchIndex, error) {
+	indices := []elasticsearchIndex{}
+	if err := n.get("_cat/indices/"+pattern+"?format=json", &indices); err != nil {
+		return nil, err
+	}
+	return indices, nil
+}
+
+// publishAddresses returns the HTTP addresses the cluster's nodes publish, which clients that
+// sniff the cluster connect to instead of the URLs they were given.
+func (n *elasticsearchNode) publishAddresses() ([]string, error) {
+	var nodes struct {
+		Nodes map[string]struct {
+			HTTP struct {
+				PublishAddress string `json:"publish_address"`
+			} `json:"http"`
+		} `json:"nodes"`
+	}
+	if err := n.get("_nodes/http", &nodes); err != nil {
+		return nil, err
+	}
+	addresses := []string{}
+	for _, node := range nodes.Nodes {
+		if len(node.HTTP.PublishAddress) != 0 {
+			addresses = append(addresses, node.HTTP.PublishAddress)
+		}
+	}
+	return addresses, nil
+}
+
+// countMatches returns how many documents of the indices matching a pattern hold a phrase.
+func (n *elasticsearchNode) countMatches(pattern, phrase string) (int, error) {
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"query_string": map[string]interface{}{"query": strconv.Quote(phrase)},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	data, err := n.request("POST", pattern+"/_count", query)
+	if err != nil {
+		return 0, err
+	}
+	var count struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(data, &count); err != nil {
+		return 0, fmt.Errorf("Failed to parse Elasticsearch response for %s/_count: %s", pattern, err)
+	}
+	return count.Count, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// exportScaleObjects writes the objects of every kind to dir/<kind>/<object>.yaml. YAML keys are
+// sorted so that exports of an unchanged instance are identical, and files of objects which no
+// longer exist or were retired are removed so that a diff of two exports shows them as deleted.
+// Applying the export then leaves retired objects retired.
+func exportScaleObjects(adapter *scaleAPIAdapter, dir string, fetch func(kind *scaleObjectKind) (map[string]*scaleObject, error)) error {
+	for i := range scaleObjectKinds {
+		kind := &scaleObjectKinds[i]
+		objects, err := fetch(kind)
+		if err != nil {
+			return err
+		}
+		kindDir := filepath.Join(dir, kind.Name)
+		if err := os.MkdirAll(kindDir, 0755); err != nil {
+			return err
+		}
+		written := map[string]bool{}
+		for _, key := range sortedObjectKeys(objects) {
+			object := objects[key].Object
+			if kind.retired(object) {
+				continue
+			}
+			data, err := yaml.Marshal(kind.stripServerFields(adapter, object))
+			if err != nil {
+				return fmt.Errorf("Failed to write %s %s: %s", kind.Name, key, err)
+			}
+			name := kind.fileName(adapter, object)
+			if err := ioutil.WriteFile(filepath.Join(kindDir, name), data, 0644); err != nil {
+				return err
+			}
+			written[name] = true
+		}
+		stale, err := filepath.Glob(filepath.Join(kindDir, "*.yaml"))
+		if err != nil {
+			return err
+		}
+		for _, path := range stale {
+			if !written[filepath.Base(path)] {
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+			}
+		}
+		client.PrintMessage("Exported %d %s to %s", len(written), kind.Name, kindDir)
+	}
+	return nil
+}
+
+type exportHandler struct {
+	out string
+}
+
+func (cmd *exportHandler) handleExport(c *kingpin.ParseContext) error {
+	adapter, err := scaleAPI()
+	if err != nil {
+		return err
+	}
+	if err := exportScaleObjects(adapter, cmd.out, fetchScaleObjects); err != nil {
+		return err
+	}
+	client.PrintMessage("Exported the Scale configuration to %s.", strings.TrimRight(cmd.out, "/"))
+	return nil
+}
+
+func handleExportSection(app *kingpin.Application) {
+	cmd := &exportHandler{}
+	export := app.Command("export", "Export job types, recipe types, workspaces, Strikes and Scans as YAML files").Action(cmd.handleExport)
+	export.Flag("out", "Directory to write the export to").Required().StringVar(&cmd.out)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// The default pod replace and restart commands treat every pod alike, but the db and rabbitmq
+// pods of svc.yml keep Scale's state on their volumes: replacing db-0 loses every job, recipe and
+// workspace, and replacing rabbitmq-0 drops the queued messages. The guard refuses those unless
+// the user acknowledges the data loss, offers to export the Scale configuration first, and records
+// every replace and restart in ~/.dcos-scale-audit.log.
+
+const auditLogFileName = ".dcos-scale-audit.log"
+
+// statefulPods are the pods of svc.yml with persistent volumes, and what their loss means.
+var statefulPods = map[string]string{
+	"db":       "the Scale database (jobs, recipes, workspaces and all other configuration)",
+	"rabbitmq": "the broker's queued messages",
+}
+
+var podIndexSuffix = regexp.MustCompile(`-[0-9]+$`)
+
+// podType returns the pod type of a pod instance name, eg db for db-0.
+func podType(pod string) string {
+	return podIndexSuffix.ReplaceAllString(pod, "")
+}
+
+type auditEntry struct {
+	Time        string `json:"time"`
+	User        string `json:"user"`
+	ClusterURL  string `json:"clusterUrl"`
+	ServiceName string `json:"serviceName"`
+	Action      string `json:"action"`
+	Pod         string `json:"pod"`
+	Outcome     string `json:"outcome"`
+	ExportDir   string `json:"exportDir,omitempty"`
+}
+
+func auditLogPath() string {
+	return homePath(auditLogFileName)
+}
+
+// appendAuditLog adds an entry to the audit log, one JSON object per line.
+func appendAuditLog(entry auditEntry) error {
+	entry.Time = time.Now().UTC().Format(time.RFC3339)
+	if current, err := user.Current(); err == nil {
+		entry.User = current.Username
+	}
+	entry.ClusterURL = config.DcosUrl
+	entry.ServiceName = config.ServiceName
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(auditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// snapshotScaleConfig exports the Scale configuration to dir, along with the service options.
+func snapshotScaleConfig(dir string) error {
+	adapter, err := scaleAPI()
+	if err != nil {
+		return err
+	}
+	if err := exportScaleObjects(adapter, dir, fetchScaleObjects); err != nil {
+		return err
+	}
+	options, err := describeServiceOptions()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(options, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "options.json"), data, 0600)
+}
+
+type podGuard struct {
+	action         string
+	understandLoss bool
+	exportDir      string
+	skipExport     bool
+}
+
+// argValue returns the value given for an argument registered by the SDK's default sections,
+// whose values are bound to the SDK's own handlers.
+func argValue(c *kingpin.ParseContext, name string) string {
+	for _, element := range c.Elements {
+		if arg, ok := element.Clause.(*kingpin.ArgClause); ok && arg.Model().Name == name && element.Value != nil {
+			return *element.Value
+		}
+	}
+	return ""
+}
+
+// askExport asks whether to export the configuration first, defaulting to yes.
+func askExport(dir string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	fmt.Printf("Export the Scale configuration to %s first? [Y/n]: ", dir)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+func (g *podGuard) check(c *kingpin.ParseContext) error {
+	pod := argValue(c, "pod")
+	entry := auditEntry{Action: "pod " + g.action, Pod: pod}
+	loses, stateful := statefulPods[podType(pod)]
+	if !stateful {
+		entry.Outcome = "allowed"
+		return appendAuditLog(entry)
+	}
+	if !g.understandLoss {
+		entry.Outcome = "refused"
+		if err := appendAuditLog(entry); err != nil {
+			return err
+		}
+		if g.action == "replace" {
+			return fmt.Errorf("%s is a stateful pod: replacing it loses %s. Pass --i-understand-data-loss to replace it anyway", pod, loses)
+		}
+		return fmt.Errorf("%s is a stateful pod: restarting it interrupts %s and risks losing data which was not yet written. Pass --i-understand-data-loss to restart it anyway", pod, loses)
+	}
+
+	dir := g.exportDir
+	if len(dir) == 0 && !g.skipExport {
+		suggested := fmt.Sprintf("scale-export-%s", time.Now().Format("20060102-150405"))
+		if askExport(suggested) {
+			dir = suggested
+		}
+	}
+	if len(dir) != 0 {
+		if err := snapshotScaleConfig(dir); err != nil {
+			entry.Outcome = "refused"
+			appendAuditLog(entry)
+			return fmt.Errorf("Failed to export the Scale configuration, not continuing: %s", err)
+		}
+		entry.ExportDir = dir
+	}
+	entry.Outcome = "acknowledged data loss"
+	if err := appendAuditLog(entry); err != nil {
+		return err
+	}
+	client.PrintMessage("Continuing with %s of stateful pod %s, recorded in %s", g.action, pod, auditLogPath())
+	return nil
+}
+
+// handlePodGuardSection adds the guard to the pod replace and restart commands of the default
+// sections.
+func handlePodGuardSection(app *kingpin.Application) {
+	pod := app.GetCommand("pod")
+	if pod == nil {
+		return
+	}
+	for _, action := range []string{"replace", "restart"} {
+		command := pod.GetCommand(action)
+		if command == nil {
+			continue
+		}
+		guard := &podGuard{action: action}
+		command.Flag("i-understand-data-loss", "Allow replacing or restarting the stateful db and rabbitmq pods").BoolVar(&guard.understandLoss)
+		command.Flag("export-dir", "Export the Scale configuration to this directory before a stateful pod is touched").StringVar(&guard.exportDir)
+		command.Flag("skip-export", "Do not offer to export the Scale configuration first").BoolVar(&guard.skipExport)
+		command.PreAction(guard.check)
+	}
+}
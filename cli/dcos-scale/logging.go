@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Scale's logstash ships the logs of the scheduler and of every job execution to the Elasticsearch
+// cluster of logging.elasticsearch-urls, where the Scale UI reads them back from. With
+// logging.elasticsearch-lb false the client sniffs the cluster and connects to the addresses its
+// nodes publish instead of the URLs given, so a single instance or a load balancer needs it true.
+
+// scaleLogIndexPatterns are the indices Scale's logstash writes to, one per day.
+var scaleLogIndexPatterns = []string{"scalelogs-*"}
+
+type elasticsearchNodeCheck struct {
+	URL         string `json:"url" yaml:"url"`
+	OK          bool   `json:"ok" yaml:"ok"`
+	Version     string `json:"version,omitempty" yaml:"version,omitempty"`
+	ClusterName string `json:"clusterName,omitempty" yaml:"clusterName,omitempty"`
+	ClusterUUID string `json:"clusterUUID,omitempty" yaml:"clusterUUID,omitempty"`
+	Health      string `json:"health,omitempty" yaml:"health,omitempty"`
+	Nodes       int    `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+	Error       string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type loggingCheck struct {
+	LoadBalanced     bool                     `json:"loadBalanced" yaml:"loadBalanced"`
+	URLs             []elasticsearchNodeCheck `json:"urls" yaml:"urls"`
+	PublishAddresses []string                 `json:"publishAddresses,omitempty" yaml:"publishAddresses,omitempty"`
+	Indices          map[string][]string      `json:"indices" yaml:"indices"`
+	Warnings         []string                 `json:"warnings" yaml:"warnings"`
+	Problems         []string                 `json:"problems" yaml:"problems"`
+}
+
+// checkElasticsearchNode reads the version and cluster health through one URL.
+func checkElasticsearchNode(node *elasticsearchNode) elasticsearchNodeCheck {
+	check := elasticsearchNodeCheck{URL: node.String()}
+	info, err := node.info()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Version, check.ClusterName, check.ClusterUUID = info.Version.Number, info.ClusterName, info.ClusterUUID
+	health, err := node.health()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Health, check.Nodes = health.Status, health.NumberOfNodes
+	// a red cluster has unassigned primary shards and fails writes to them
+	check.OK = health.Status != "red"
+	if !check.OK {
+		check.Error = "cluster health is red"
+	}
+	return check
+}
+
+// elasticsearchLBWarnings returns the combinations of URLs and elasticsearch-lb which config.json
+// warns against.
+func elasticsearchLBWarnings(urls int, loadBalanced bool) []string {
+	if loadBalanced && urls > 1 {
+		return []string{fmt.Sprintf("logging.elasticsearch-lb is true but %d URLs are given: behind a load balancer give only its URL", urls)}
+	}
+	if !loadBalanced && urls == 1 {
+		return []string{"logging.elasticsearch-lb is false with a single URL: the client will sniff the cluster and connect to the addresses its nodes publish, set it to true for a single instance or a load balancer"}
+	}
+	return nil
+}
+
+type loggingHandler struct {
+	optionsFile string
+	timeout     time.Duration
+	urls        string
+	indices     []string
+	useIP       bool
+	wait        time.Duration
+	interval    time.Duration
+}
+
+// elasticsearchNodes returns the Elasticsearch URLs to check and whether they are load balanced.
+func (cmd *loggingHandler) elasticsearchNodes() ([]*elasticsearchNode, bool, error) {
+	options, err := serviceOptions(cmd.optionsFile)
+	if err != nil {
+		return nil, false, err
+	}
+	loadBalanced := optionString(options, "logging.elasticsearch-lb", "false") == "true"
+	urls := cmd.urls
+	if len(urls) == 0 {
+		urls = optionString(options, "logging.elasticsearch-urls", "")
+	}
+	if len(strings.TrimSpace(urls)) == 0 {
+		return nil, false, fmt.Errorf("logging.elasticsearch-urls is empty, Scale then uses the DC/OS Elasticsearch package: give its URL with --urls to check it")
+	}
+	nodes, err := parseElasticsearchURLs(urls, cmd.timeout)
+	return nodes, loadBalanced, err
+}
+
+func (cmd *loggingHandler) handleCheck(c *kingpin.ParseContext) error {
+	nodes, loadBalanced, err := cmd.elasticsearchNodes()
+	if err != nil {
+		return err
+	}
+	result := &loggingCheck{
+		LoadBalanced: loadBalanced,
+		URLs:         []elasticsearchNodeCheck{},
+		Indices:      map[string][]string{},
+		Warnings:     elasticsearchLBWarnings(len(nodes), loadBalanced),
+		Problems:     []string{},
+	}
+	var reachable *elasticsearchNode
+	clusters := map[string]bool{}
+	for _, node := range nodes {
+		check := checkElasticsearchNode(node)
+		if check.OK {
+			if reachable == nil {
+				reachable = node
+			}
+			clusters[check.ClusterUUID] = true
+		} else {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s: %s", check.URL, check.Error))
+		}
+		result.URLs = append(result.URLs, check)
+	}
+	if len(clusters) > 1 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("the URLs lead to %d different clusters, logs will be split between them", len(clusters)))
+	}
+
+	if reachable != nil {
+		if !loadBalanced {
+			addresses, err := reachable.publishAddresses()
+			if err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("cannot list the addresses the nodes publish: %s", err))
+			}
+			sort.Strings(addresses)
+			result.PublishAddresses = addresses
+		}
+		patterns := cmd.indices
+		if len(patterns) == 0 {
+			patterns = scaleLogIndexPatterns
+		}
+		for _, pattern := range patterns {
+			indices, err := reachable.indices(pattern)
+			if err != nil {
+				result.Problems = append(result.Problems, fmt.Sprintf("cannot list the indices matching %s: %s", pattern, err))
+				continue
+			}
+			names := []string{}
+			for _, index := range indices {
+				names = append(names, index.Index)
+			}
+			sort.Strings(names)
+			result.Indices[pattern] = names
+			if len(names) == 0 {
+				result.Problems = append(result.Problems, fmt.Sprintf("no index matches %s, no logs have reached Elasticsearch", pattern))
+			}
+		}
+	}
+
+	if printed, err := printStructured(result); !printed {
+		rows := [][]string{}
+		for _, check := range result.URLs {
+			status := "OK"
+			if !check.OK {
+				status = "FAIL"
+			}
+			rows = append(rows, []string{check.URL, status, check.Version, check.ClusterName, check.Health, fmt.Sprint(check.Nodes)})
+		}
+		printTable([]string{"URL", "STATUS", "VERSION", "CLUSTER", "HEALTH", "NODES"}, rows)
+		if len(result.PublishAddresses) != 0 {
+			client.PrintMessage("\nThe client will sniff and connect to: %s", strings.Join(result.PublishAddresses, ", "))
+		}
+		for _, pattern := range sortedIndexPatterns(result.Indices) {
+			if names := result.Indices[pattern]; len(names) != 0 {
+				client.PrintMessage("\n%s: %d indices, the latest %s", pattern, len(names), names[len(names)-1])
+			}
+		}
+		for _, warning := range result.Warnings {
+			client.PrintMessage("\nWARNING: %s", warning)
+		}
+		for _, problem := range result.Problems {
+			client.PrintMessage("\nERROR: %s", problem)
+		}
+	} else if err != nil {
+		return err
+	}
+	if len(result.Problems) != 0 {
+		return fmt.Errorf("Elasticsearch check found %d problems", len(result.Problems))
+	}
+	return nil
+}
+
+// logstashTarget returns the network and address to send log events to: logging.logstash-address,
+// eg tcp://host:9229, or else the logging port of the logstash pod.
+func logstashTarget(options map[string]interface{}, useIP bool) (string, string, error) {
+	address := optionString(options, "logging.logstash-address", "")
+	if len(address) == 0 {
+		return "tcp", serviceEndpointAddress("logging", "logstash", logstashLoggingPort, useIP), nil
+	}
+	network := "tcp"
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, address = address[:i], strings.TrimRight(address[i+3:], "/")
+	}
+	if network != "tcp" && network != "udp" {
+		return "", "", fmt.Errorf("logging.logstash-address uses %s, expected tcp or udp", network)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(logstashLoggingPort))
+	}
+	return network, address, nil
+}
+
+// sendSyslogEvent sends one RFC 5424 syslog message, as the Docker syslog log driver of Scale's
+// containers does.
+func sendSyslogEvent(network, address, message string, timeout time.Duration) error {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	hostname, _ := os.Hostname()
+	if len(hostname) == 0 {
+		hostname = "-"
+	}
+	// facility user, severity info
+	line := fmt.Sprintf("<14>1 %s %s dcos-scale-selftest %d - - %s\n", time.Now().UTC().Format(time.RFC3339Nano), hostname, os.Getpid(), message)
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write([]byte(line))
+	return err
+}
+
+// logEventIndexedCondition holds once a search for the token finds the event.
+func logEventIndexedCondition(node *elasticsearchNode, patterns []string, token string) waitCondition {
+	return func() (bool, string, error) {
+		for _, pattern := range patterns {
+			count, err := node.countMatches(pattern, token)
+			// an index which does not exist yet has no events, other errors than the cluster
+			// coming up will not go away by searching again
+			if err != nil && !isStatus(err, 404) {
+				return false, "", apiFailure(err)
+			}
+			if count != 0 {
+				return true, fmt.Sprintf("event found in %s", pattern), nil
+			}
+		}
+		return false, fmt.Sprintf("waiting for the event in %s", strings.Join(patterns, ", ")), nil
+	}
+}
+
+// logSelftest is the outcome of a logging selftest.
+type logSelftest struct {
+	Token    string        `json:"token" yaml:"token"`
+	Logstash string        `json:"logstash" yaml:"logstash"`
+	URL      string        `json:"elasticsearch" yaml:"elasticsearch"`
+	Latency  time.Duration `json:"latencyNanos" yaml:"latencyNanos"`
+}
+
+func (cmd *loggingHandler) handleSelftest(c *kingpin.ParseContext) error {
+	options, err := serviceOptions(cmd.optionsFile)
+	if err != nil {
+		return err
+	}
+	network, address, err := logstashTarget(options, cmd.useIP)
+	if err != nil {
+		return err
+	}
+	nodes, _, err := cmd.elasticsearchNodes()
+	if err != nil {
+		return err
+	}
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	result := &logSelftest{
+		Token:    "dcos-scale-selftest-" + hex.EncodeToString(random),
+		Logstash: network + "://" + address,
+		URL:      nodes[0].String(),
+	}
+	patterns := cmd.indices
+	if len(patterns) == 0 {
+		patterns = scaleLogIndexPatterns
+	}
+
+	sent := time.Now()
+	if err := sendSyslogEvent(network, address, "Logging pipeline self-test "+result.Token, cmd.timeout); err != nil {
+		return fmt.Errorf("Failed to send the test event to logstash at %s: %s", result.Logstash, err)
+	}
+	client.PrintMessage("Sent test event %s to logstash at %s.", result.Token, result.Logstash)
+	if code, err := waitFor(logEventIndexedCondition(nodes[0], patterns, result.Token), cmd.wait, cmd.interval); code == exitWaitAPIError {
+		return fmt.Errorf("Failed to search Elasticsearch at %s: %s", result.URL, err)
+	} else if code != 0 {
+		return fmt.Errorf("The test event did not reach Elasticsearch at %s: %s", result.URL, err)
+	}
+	result.Latency = time.Since(sent)
+	if printed, err := printStructured(result); printed {
+		return err
+	}
+	client.PrintMessage("The test event was searchable in Elasticsearch after %s.", roundLatency(result.Latency))
+	return nil
+}
+
+func sortedIndexPatterns(indices map[string][]string) []string {
+	patterns := make([]string, 0, len(indices))
+	for pattern := range indices {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+func handleLoggingSection(app *kingpin.Application) {
+	cmd := &loggingHandler{}
+	logging := app.Command("logging", "Check the logging pipeline from logstash to Elasticsearch")
+	logging.Flag("options", "Options file to take the logging settings from instead of the installed service").StringVar(&cmd.optionsFile)
+	logging.Flag("timeout", "Timeout for each request").Default("10s").DurationVar(&cmd.timeout)
+
+	check := logging.Command("check", "Check the Elasticsearch cluster of logging.elasticsearch-urls").Action(cmd.handleCheck)
+	check.Flag("urls", "Comma separated Elasticsearch URLs to check instead of logging.elasticsearch-urls").StringVar(&cmd.urls)
+	check.Flag("index", fmt.Sprintf("Index patterns which must exist, defaults to %s", strings.Join(scaleLogIndexPatterns, ", "))).StringsVar(&cmd.indices)
+
+	selftest := logging.Command("selftest", "Send a test event to logstash and wait for it to be indexed in Elasticsearch").Action(cmd.handleSelftest)
+	selftest.Flag("urls", "Comma separated Elasticsearch URLs to search instead of logging.elasticsearch-urls").StringVar(&cmd.urls)
+	selftest.Flag("index", fmt.Sprintf("Index patterns to search, defaults to %s", strings.Join(scaleLogIndexPatterns, ", "))).StringsVar(&cmd.indices)
+	selftest.Flag("ip", "Send to the logstash pod's IP address instead of its DNS name").BoolVar(&cmd.useIP)
+	selftest.Flag("wait", "Time to wait for the event to be indexed").Default("2m").DurationVar(&cmd.wait)
+	selftest.Flag("interval", "Time between searches").Default("2s").DurationVar(&cmd.interval)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestLogEventIndexedConditionExitCodes searches a stand-in Elasticsearch answering every count
+// with the given status.
+func TestLogEventIndexedConditionExitCodes(t *testing.T) {
+	tests := []struct {
+		status int
+		code   int
+	}{
+		{404, exitWaitTimeout},
+		{503, exitWaitTimeout},
+		{401, exitWaitAPIError},
+		{500, exitWaitAPIError},
+	}
+	for _, test := range tests {
+		searches := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			searches++
+			w.WriteHeader(test.status)
+			w.Write([]byte(`{"error": "stand-in"}`))
+		}))
+		serverURL, _ := url.Parse(server.URL)
+		node := &elasticsearchNode{URL: serverURL, Timeout: 5 * time.Second}
+		code, err := waitFor(logEventIndexedCondition(node, []string{"scale-*"}, "token"), 50*time.Millisecond, 10*time.Millisecond)
+		server.Close()
+		if code != test.code {
+			t.Errorf("a %d exited with %d (%v), expected %d", test.status, code, err, test.code)
+		}
+		if test.code == exitWaitAPIError && searches != 1 {
+			t.Errorf("a %d was searched %d times, expected once", test.status, searches)
+		}
+	}
+}
@@ -9,6 +9,28 @@ func main() {
 	app := cli.New()
 
 	cli.HandleDefaultSections(app)
+	handlePodGuardSection(app)
+	handleProfilesSection(app)
+	handlePlanWatchSection(app)
+	handlePlanGraphSection(app)
+	handleOptionsSection(app)
+	handleSecretsSection(app)
+	handleUpdateSection(app)
+	handleUpgradeSection(app)
+	handleWaitSection(app)
+	handleProbeSection(app)
+	handleSchedulerSection(app)
+	handleEndpointsConnectSection(app)
+	handleScaleOutSection(app)
+	handleCapacitySection(app)
+	handlePlacementSection(app)
+	handleExportSection(app)
+	handleApplySection(app)
+	handlePromoteSection(app)
+	handleBrokerSection(app)
+	handleLoggingSection(app)
+	handleDBSection(app)
+	handleDockerCredentialsSection(app)
 
 	kingpin.MustParse(app.Parse(cli.GetArguments()))
 }
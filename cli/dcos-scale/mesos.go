@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/config"
+)
+
+// mesosResources are the scalar resources of an agent or task.
+type mesosResources struct {
+	CPUs float64 `json:"cpus" yaml:"cpus"`
+	Mem  float64 `json:"mem" yaml:"mem"`
+	Disk float64 `json:"disk" yaml:"disk"`
+}
+
+func (r mesosResources) minus(other mesosResources) mesosResources {
+	return mesosResources{CPUs: r.CPUs - other.CPUs, Mem: r.Mem - other.Mem, Disk: r.Disk - other.Disk}
+}
+
+func (r mesosResources) plus(other mesosResources) mesosResources {
+	return mesosResources{CPUs: r.CPUs + other.CPUs, Mem: r.Mem + other.Mem, Disk: r.Disk + other.Disk}
+}
+
+func (r mesosResources) times(n float64) mesosResources {
+	return mesosResources{CPUs: r.CPUs * n, Mem: r.Mem * n, Disk: r.Disk * n}
+}
+
+// fits returns how many times the demand fits into the resources, without bound for an empty
+// demand.
+func (r mesosResources) fits(demand mesosResources) int {
+	count := math.Inf(1)
+	for _, pair := range [][2]float64{{r.CPUs, demand.CPUs}, {r.Mem, demand.Mem}, {r.Disk, demand.Disk}} {
+		if pair[1] > 0 {
+			count = math.Min(count, math.Floor(pair[0]/pair[1]))
+		}
+	}
+	if math.IsInf(count, 1) {
+		return math.MaxInt32
+	}
+	if count < 0 {
+		return 0
+	}
+	return int(count)
+}
+
+type mesosAgent struct {
+	ID            string                 `json:"id"`
+	Hostname      string                 `json:"hostname"`
+	Active        bool                   `json:"active"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	Resources     mesosResources         `json:"resources"`
+	UsedResources mesosResources         `json:"used_resources"`
+}
+
+func (a *mesosAgent) free() mesosResources {
+	return a.Resources.minus(a.UsedResources)
+}
+
+// field returns the value of a placement field of the agent: its hostname or an attribute.
+func (a *mesosAgent) field(name string) (string, bool) {
+	if name == "hostname" {
+		return a.Hostname, true
+	}
+	value, ok := a.Attributes[name]
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(optionString(map[string]interface{}{name: value}, name, "")), true
+}
+
+type mesosTask struct {
+	Name      string         `json:"name"`
+	SlaveID   string         `json:"slave_id"`
+	State     string         `json:"state"`
+	Resources mesosResources `json:"resources"`
+}
+
+type mesosFramework struct {
+	Name  string      `json:"name"`
+	Tasks []mesosTask `json:"tasks"`
+}
+
+// mesosState holds the parts of the Mesos master state the CLI works with.
+type mesosState struct {
+	Slaves     []mesosAgent     `json:"slaves"`
+	Frameworks []mesosFramework `json:"frameworks"`
+}
+
+func fetchMesosState() (*mesosState, error) {
+	var state mesosState
+	if err := clusterGetJSON("mesos/master/state", &state); err != nil {
+		return nil, fmt.Errorf("Failed to read the Mesos master state: %s", err)
+	}
+	return &state, nil
+}
+
+func (s *mesosState) activeAgents() []*mesosAgent {
+	agents := []*mesosAgent{}
+	for i := range s.Slaves {
+		if s.Slaves[i].Active {
+			agents = append(agents, &s.Slaves[i])
+		}
+	}
+	return agents
+}
+
+func (s *mesosState) agent(id string) *mesosAgent {
+	for i := range s.Slaves {
+		if s.Slaves[i].ID == id {
+			return &s.Slaves[i]
+		}
+	}
+	return nil
+}
+
+// serviceTasks returns the running tasks of the service's framework whose names start with prefix.
+func (s *mesosState) serviceTasks(prefix string) []mesosTask {
+	tasks := []mesosTask{}
+	for _, framework := range s.Frameworks {
+		if framework.Name != strings.Trim(config.ServiceName, "/") {
+			continue
+		}
+		for _, task := range framework.Tasks {
+			if strings.HasPrefix(task.Name, prefix) && task.State == "TASK_RUNNING" {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	return tasks
+}
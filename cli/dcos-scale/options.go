@@ -0,0 +1,904 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// Options files are read into an ordered tree rather than a map so that conversions keep the
+// author's key order, and so that comments from YAML and TOML sources can be carried across.
+
+var optionsFormats = []string{"json", "yaml", "toml"}
+
+type optionsEntry struct {
+	Key string
+	// Value is a scalar, an *optionsTree for nested objects, or a []interface{} of either, or of
+	// further lists.
+	Value       interface{}
+	HeadComment []string
+	LineComment string
+}
+
+type optionsTree struct {
+	// Comment is a comment block at the top of the file which is not attached to any key.
+	Comment []string
+	Entries []*optionsEntry
+}
+
+func (t *optionsTree) get(key string) *optionsEntry {
+	for _, entry := range t.Entries {
+		if entry.Key == key {
+			return entry
+		}
+	}
+	return nil
+}
+
+// lookup returns the entry at a dotted path such as "db.db-host".
+func (t *optionsTree) lookup(path []string) *optionsEntry {
+	entry := t.get(path[0])
+	if entry == nil || len(path) == 1 {
+		return entry
+	}
+	if subtree, ok := entry.Value.(*optionsTree); ok {
+		return subtree.lookup(path[1:])
+	}
+	return nil
+}
+
+func (t *optionsTree) toMap() map[string]interface{} {
+	result := make(map[string]interface{}, len(t.Entries))
+	for _, entry := range t.Entries {
+		result[entry.Key] = plainOptionsValue(entry.Value)
+	}
+	return result
+}
+
+// plainOptionsValue converts the trees of a value, including those inside lists, to maps.
+func plainOptionsValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *optionsTree:
+		return v.toMap()
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = plainOptionsValue(item)
+		}
+		return list
+	}
+	return value
+}
+
+// treeList returns the items of a list when it is a non-empty list of trees, eg a TOML array of
+// tables.
+func treeList(value interface{}) ([]*optionsTree, bool) {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	trees := make([]*optionsTree, 0, len(list))
+	for _, item := range list {
+		tree, ok := item.(*optionsTree)
+		if !ok {
+			return nil, false
+		}
+		trees = append(trees, tree)
+	}
+	return trees, true
+}
+
+func (t *optionsTree) hasScalars() bool {
+	for _, entry := range t.Entries {
+		if _, ok := entry.Value.(*optionsTree); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *optionsTree) hasComments() bool {
+	if len(t.Comment) != 0 {
+		return true
+	}
+	for _, entry := range t.Entries {
+		if len(entry.HeadComment) != 0 || len(entry.LineComment) != 0 {
+			return true
+		}
+		if subtree, ok := entry.Value.(*optionsTree); ok && subtree.hasComments() {
+			return true
+		}
+	}
+	return false
+}
+
+// optionsFormatOf guesses the format of an options file from its extension, defaulting to JSON
+// which is what the package tooling has always expected.
+func optionsFormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	default:
+		return "json"
+	}
+}
+
+// readOptionsFile reads a JSON, YAML or TOML options file into the map form that is submitted
+// to the package service.
+func readOptionsFile(path string) (map[string]interface{}, error) {
+	tree, err := loadOptionsFile(path, "")
+	if err != nil {
+		return nil, err
+	}
+	return tree.toMap(), nil
+}
+
+func loadOptionsFile(path, format string) (*optionsTree, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(format) == 0 {
+		format = optionsFormatOf(path)
+	}
+	tree, err := parseOptions(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s as %s: %s", path, format, err)
+	}
+	return tree, nil
+}
+
+func parseOptions(data []byte, format string) (*optionsTree, error) {
+	switch format {
+	case "json":
+		return parseJSONOptions(data)
+	case "yaml":
+		return parseYAMLOptions(data)
+	case "toml":
+		return parseTOMLOptions(data)
+	}
+	return nil, fmt.Errorf("Unsupported options format: %s", format)
+}
+
+func formatOptions(tree *optionsTree, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "json":
+		err = writeJSONOptions(&buf, tree, "")
+		buf.WriteString("\n")
+	case "yaml":
+		writeFileComment(&buf, tree.Comment)
+		err = writeYAMLOptions(&buf, tree, "")
+	case "toml":
+		writeFileComment(&buf, tree.Comment)
+		err = writeTOMLOptions(&buf, tree, nil, new(bool))
+	default:
+		err = fmt.Errorf("Unsupported options format: %s", format)
+	}
+	return buf.Bytes(), err
+}
+
+// JSON
+
+func parseJSONOptions(data []byte) (*optionsTree, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("expected a JSON object at the top level")
+	}
+	return decodeJSONObject(decoder)
+}
+
+func decodeJSONObject(decoder *json.Decoder) (*optionsTree, error) {
+	tree := &optionsTree{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		value, err := decodeJSONValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		tree.Entries = append(tree.Entries, &optionsEntry{Key: key, Value: value})
+	}
+	_, err := decoder.Token()
+	return tree, err
+}
+
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '{' {
+			return decodeJSONObject(decoder)
+		}
+		list := []interface{}{}
+		for decoder.More() {
+			item, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		_, err := decoder.Token()
+		return list, err
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i, nil
+		}
+		return value.Float64()
+	default:
+		return value, nil
+	}
+}
+
+func writeJSONOptions(w io.Writer, tree *optionsTree, indent string) error {
+	if len(tree.Entries) == 0 {
+		_, err := io.WriteString(w, "{}")
+		return err
+	}
+	io.WriteString(w, "{\n")
+	for i, entry := range tree.Entries {
+		key, _ := json.Marshal(entry.Key)
+		fmt.Fprintf(w, "%s  %s: ", indent, key)
+		if err := writeJSONValue(w, entry.Value, indent+"  "); err != nil {
+			return err
+		}
+		if i < len(tree.Entries)-1 {
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, "\n")
+	}
+	_, err := fmt.Fprintf(w, "%s}", indent)
+	return err
+}
+
+// writeJSONValue writes trees in their key order, also inside lists. Lists holding no trees stay
+// on one line.
+func writeJSONValue(w io.Writer, value interface{}, indent string) error {
+	switch v := value.(type) {
+	case *optionsTree:
+		return writeJSONOptions(w, v, indent)
+	case []interface{}:
+		if !containsTree(v) {
+			break
+		}
+		io.WriteString(w, "[\n")
+		for i, item := range v {
+			io.WriteString(w, indent+"  ")
+			if err := writeJSONValue(w, item, indent+"  "); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, "\n")
+		}
+		_, err := fmt.Fprintf(w, "%s]", indent)
+		return err
+	}
+	data, err := json.Marshal(plainOptionsValue(value))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// containsTree returns whether a list holds a tree at any depth.
+func containsTree(list []interface{}) bool {
+	for _, item := range list {
+		switch v := item.(type) {
+		case *optionsTree:
+			return true
+		case []interface{}:
+			if containsTree(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// YAML
+
+func parseYAMLOptions(data []byte) (*optionsTree, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	tree, err := yamlMapSliceToTree(doc)
+	if err != nil {
+		return nil, err
+	}
+	tree.Comment = attachComments(tree, scanYAMLComments(data))
+	return tree, nil
+}
+
+func yamlMapSliceToTree(slice yaml.MapSlice) (*optionsTree, error) {
+	tree := &optionsTree{}
+	for _, item := range slice {
+		key, ok := item.Key.(string)
+		if !ok {
+			key = fmt.Sprint(item.Key)
+		}
+		value, err := yamlValue(item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		tree.Entries = append(tree.Entries, &optionsEntry{Key: key, Value: value})
+	}
+	return tree, nil
+}
+
+func yamlValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		return yamlMapSliceToTree(v)
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			converted, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return list, nil
+	case int:
+		return int64(v), nil
+	default:
+		return v, nil
+	}
+}
+
+var yamlKeyLine = regexp.MustCompile(`^(\s*)("[^"]*"|'[^']*'|[^\s#:][^:#]*?)\s*:(\s|$)`)
+
+// scanYAMLComments maps dotted key paths to the comments written above them and at the end of
+// their line. yaml.v2 drops comments while decoding, so they are recovered from the source text.
+// A comment block separated from the first key by a blank line is returned under the "" path.
+func scanYAMLComments(data []byte) map[string]*optionsEntry {
+	type level struct {
+		indent int
+		key    string
+	}
+	comments := map[string]*optionsEntry{}
+	var stack []level
+	var pending []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			pending = append(pending, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+			continue
+		}
+		match := yamlKeyLine.FindStringSubmatch(line)
+		if match == nil {
+			if len(trimmed) == 0 && len(stack) == 0 && len(pending) != 0 && len(comments) == 0 {
+				comments[""] = &optionsEntry{HeadComment: pending}
+				pending = nil
+			}
+			continue
+		}
+		indent := len(match[1])
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		key := strings.Trim(match[2], `"'`)
+		path := make([]string, 0, len(stack)+1)
+		for _, l := range stack {
+			path = append(path, l.key)
+		}
+		path = append(path, key)
+		entry := &optionsEntry{HeadComment: pending, LineComment: trailingComment(line[len(match[0]):])}
+		if len(entry.HeadComment) != 0 || len(entry.LineComment) != 0 {
+			comments[strings.Join(path, ".")] = entry
+		}
+		pending = nil
+		stack = append(stack, level{indent: indent, key: key})
+	}
+	return comments
+}
+
+// trailingComment returns the comment at the end of a value, ignoring '#' inside quoted strings.
+func trailingComment(rest string) string {
+	var quote rune
+	previous := ' '
+	for i, r := range rest {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (previous == ' ' || previous == '\t'):
+			return strings.TrimSpace(rest[i+1:])
+		}
+		previous = r
+	}
+	return ""
+}
+
+// attachComments copies scanned comments onto the entries of a tree, returning the file comment.
+func attachComments(tree *optionsTree, comments map[string]*optionsEntry) []string {
+	var walk func(t *optionsTree, prefix string)
+	walk = func(t *optionsTree, prefix string) {
+		for _, entry := range t.Entries {
+			path := prefix + entry.Key
+			if comment, ok := comments[path]; ok {
+				entry.HeadComment = comment.HeadComment
+				entry.LineComment = comment.LineComment
+			}
+			if subtree, ok := entry.Value.(*optionsTree); ok {
+				walk(subtree, path+".")
+			}
+		}
+	}
+	walk(tree, "")
+	if header, ok := comments[""]; ok {
+		return header.HeadComment
+	}
+	return nil
+}
+
+func writeComments(w io.Writer, indent string, comments []string) {
+	for _, comment := range comments {
+		if len(comment) == 0 {
+			fmt.Fprintf(w, "%s#\n", indent)
+		} else {
+			fmt.Fprintf(w, "%s# %s\n", indent, comment)
+		}
+	}
+}
+
+func writeFileComment(w io.Writer, comments []string) {
+	if len(comments) != 0 {
+		writeComments(w, "", comments)
+		io.WriteString(w, "\n")
+	}
+}
+
+func writeLineComment(w io.Writer, comment string) {
+	if len(comment) != 0 {
+		fmt.Fprintf(w, " # %s", comment)
+	}
+	io.WriteString(w, "\n")
+}
+
+// yamlScalar formats a value on one line, writing lists and trees in flow style.
+func yamlScalar(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, *optionsTree:
+		// JSON is valid YAML flow style, and keeps nested lists on one line
+		out, err := json.Marshal(plainOptionsValue(value))
+		return string(out), err
+	}
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func writeYAMLOptions(w io.Writer, tree *optionsTree, indent string) error {
+	for _, entry := range tree.Entries {
+		writeComments(w, indent, entry.HeadComment)
+		key, err := yamlScalar(entry.Key)
+		if err != nil {
+			return err
+		}
+		switch value := entry.Value.(type) {
+		case *optionsTree:
+			fmt.Fprintf(w, "%s%s:", indent, key)
+			writeLineComment(w, entry.LineComment)
+			if err := writeYAMLOptions(w, value, indent+"  "); err != nil {
+				return err
+			}
+		case []interface{}:
+			fmt.Fprintf(w, "%s%s:", indent, key)
+			if len(value) == 0 {
+				io.WriteString(w, " []")
+			}
+			writeLineComment(w, entry.LineComment)
+			for _, item := range value {
+				if subtree, ok := item.(*optionsTree); ok && len(subtree.Entries) != 0 {
+					// write the tree as a block, with the dash in place of its first indent
+					var buf bytes.Buffer
+					if err := writeYAMLOptions(&buf, subtree, indent+"  "); err != nil {
+						return err
+					}
+					fmt.Fprintf(w, "%s- %s", indent, strings.TrimPrefix(buf.String(), indent+"  "))
+					continue
+				}
+				scalar, err := yamlScalar(item)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s- %s\n", indent, scalar)
+			}
+		default:
+			scalar, err := yamlScalar(value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s%s: %s", indent, key, scalar)
+			writeLineComment(w, entry.LineComment)
+		}
+	}
+	return nil
+}
+
+// TOML
+
+func parseTOMLOptions(data []byte) (*optionsTree, error) {
+	doc, err := toml.LoadBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	tree := tomlToTree(doc)
+	tree.Comment = attachComments(tree, scanTOMLComments(data))
+	return tree, nil
+}
+
+// tomlToTree orders keys by their position in the source, as go-toml only keeps them in a map.
+func tomlToTree(doc *toml.Tree) *optionsTree {
+	keys := doc.Keys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		pi, pj := doc.GetPosition(keys[i]), doc.GetPosition(keys[j])
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Col < pj.Col
+	})
+	tree := &optionsTree{}
+	for _, key := range keys {
+		tree.Entries = append(tree.Entries, &optionsEntry{Key: key, Value: tomlValue(doc.GetPath([]string{key}))})
+	}
+	return tree
+}
+
+// tomlValue converts tables, arrays of tables and inline tables inside arrays to trees.
+func tomlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *toml.Tree:
+		return tomlToTree(v)
+	case []*toml.Tree:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = tomlToTree(item)
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = tomlValue(item)
+		}
+		return list
+	}
+	return value
+}
+
+var (
+	tomlTableLine      = regexp.MustCompile(`^\s*\[([^\[\]]+)\]`)
+	tomlArrayTableLine = regexp.MustCompile(`^\s*\[\[`)
+	tomlKeyLine        = regexp.MustCompile(`^\s*("[^"]*"|'[^']*'|[A-Za-z0-9_-]+)\s*=`)
+)
+
+func scanTOMLComments(data []byte) map[string]*optionsEntry {
+	comments := map[string]*optionsEntry{}
+	table := ""
+	var pending []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		var path, rest string
+		if strings.HasPrefix(trimmed, "#") {
+			pending = append(pending, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+			continue
+		} else if tomlArrayTableLine.MatchString(line) {
+			// comments are only kept on keys with a single path, not on the items of arrays
+			table, pending = "\x00", nil
+			continue
+		} else if match := tomlTableLine.FindStringSubmatch(line); match != nil {
+			parts := strings.Split(match[1], ".")
+			for i, part := range parts {
+				parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+			}
+			table = strings.Join(parts, ".")
+			path, rest = table, line[len(match[0]):]
+		} else if match := tomlKeyLine.FindStringSubmatch(line); match != nil {
+			path = strings.Trim(match[1], `"'`)
+			if len(table) != 0 {
+				path = table + "." + path
+			}
+			rest = line[len(match[0]):]
+		} else {
+			if len(trimmed) == 0 && len(table) == 0 && len(pending) != 0 && len(comments) == 0 {
+				comments[""] = &optionsEntry{HeadComment: pending}
+				pending = nil
+			}
+			continue
+		}
+		entry := &optionsEntry{HeadComment: pending, LineComment: trailingComment(rest)}
+		if len(entry.HeadComment) != 0 || len(entry.LineComment) != 0 {
+			comments[path] = entry
+		}
+		pending = nil
+	}
+	return comments
+}
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(key string) string {
+	if tomlBareKey.MatchString(key) {
+		return key
+	}
+	quoted, _ := json.Marshal(key)
+	return string(quoted)
+}
+
+func tomlScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		quoted, err := json.Marshal(v)
+		return string(quoted), err
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		formatted := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.ContainsAny(formatted, ".eE") {
+			formatted += ".0"
+		}
+		return formatted, nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			scalar, err := tomlScalar(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, scalar)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case *optionsTree:
+		// an inline table, for trees inside lists which are not arrays of tables
+		items := make([]string, 0, len(v.Entries))
+		for _, entry := range v.Entries {
+			if entry.Value == nil {
+				continue
+			}
+			scalar, err := tomlScalar(entry.Value)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, tomlKey(entry.Key)+" = "+scalar)
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	case nil:
+		return "", fmt.Errorf("TOML has no null value")
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// writeTOMLOptions writes the scalars of a table before its subtables, as TOML requires. started
+// tracks whether anything has been written yet, so tables can be separated by blank lines.
+func writeTOMLOptions(w io.Writer, tree *optionsTree, path []string, started *bool) error {
+	var tables []*optionsEntry
+	for _, entry := range tree.Entries {
+		if _, ok := entry.Value.(*optionsTree); ok {
+			tables = append(tables, entry)
+			continue
+		}
+		if _, ok := treeList(entry.Value); ok {
+			tables = append(tables, entry)
+			continue
+		}
+		if entry.Value == nil {
+			// an explicit null is the same as leaving the option unset
+			continue
+		}
+		scalar, err := tomlScalar(entry.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", strings.Join(append(path, entry.Key), "."), err)
+		}
+		writeComments(w, "", entry.HeadComment)
+		fmt.Fprintf(w, "%s = %s", tomlKey(entry.Key), scalar)
+		writeLineComment(w, entry.LineComment)
+		*started = true
+	}
+	for _, entry := range tables {
+		tablePath := append(append([]string{}, path...), entry.Key)
+		keys := make([]string, len(tablePath))
+		for i, key := range tablePath {
+			keys[i] = tomlKey(key)
+		}
+		if items, ok := treeList(entry.Value); ok {
+			for _, item := range items {
+				if *started {
+					io.WriteString(w, "\n")
+				}
+				*started = true
+				fmt.Fprintf(w, "[[%s]]\n", strings.Join(keys, "."))
+				if err := writeTOMLOptions(w, item, tablePath, started); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		subtree := entry.Value.(*optionsTree)
+		if subtree.hasScalars() || len(entry.HeadComment) != 0 || len(entry.LineComment) != 0 {
+			// a table holding only subtables needs no header of its own
+			if *started {
+				io.WriteString(w, "\n")
+			}
+			*started = true
+			writeComments(w, "", entry.HeadComment)
+			fmt.Fprintf(w, "[%s]", strings.Join(keys, "."))
+			writeLineComment(w, entry.LineComment)
+		}
+		if err := writeTOMLOptions(w, subtree, tablePath, started); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type optionsHandler struct {
+	file string
+	from string
+	to   string
+	out  string
+}
+
+func (cmd *optionsHandler) handleConvert(c *kingpin.ParseContext) error {
+	tree, err := loadOptionsFile(cmd.file, cmd.from)
+	if err != nil {
+		return err
+	}
+	if cmd.to == "json" && tree.hasComments() {
+		fmt.Fprintln(os.Stderr, "Warning: JSON has no comments, comments in the source are dropped.")
+	}
+	out, err := formatOptions(tree, cmd.to)
+	if err != nil {
+		return err
+	}
+	if len(cmd.out) == 0 {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	if err := ioutil.WriteFile(cmd.out, out, 0644); err != nil {
+		return err
+	}
+	client.PrintMessage("Wrote %s options to %s.", cmd.to, cmd.out)
+	return nil
+}
+
+func handleOptionsSection(app *kingpin.Application) {
+	cmd := &optionsHandler{}
+	options := app.Command("options", "Work with package options files")
+
+	convert := options.Command("convert", "Convert an options file between JSON, YAML and TOML").Action(cmd.handleConvert)
+	convert.Arg("file", "Options file to convert, or - for stdin").Required().StringVar(&cmd.file)
+	convert.Flag("from", "Format of the input, guessed from its extension by default").EnumVar(&cmd.from, optionsFormats...)
+	convert.Flag("to", "Format to convert to").Required().EnumVar(&cmd.to, optionsFormats...)
+	convert.Flag("out", "File to write, defaults to stdout").StringVar(&cmd.out)
+
+	validate := options.Command("validate", "Check an options file for mistakes the package schema does not catch").Action(cmd.handleValidate)
+	validate.Arg("file", "Options file to check, or - for stdin").Required().StringVar(&cmd.file)
+}
+
+// flattenOptions returns the leaf values of nested options keyed by dotted path.
+func flattenOptions(options map[string]interface{}) map[string]interface{} {
+	flat := map[string]interface{}{}
+	var walk func(node map[string]interface{}, prefix string)
+	walk = func(node map[string]interface{}, prefix string) {
+		for key, value := range node {
+			if nested, ok := value.(map[string]interface{}); ok {
+				walk(nested, prefix+key+".")
+			} else {
+				flat[prefix+key] = value
+			}
+		}
+	}
+	walk(options, "")
+	return flat
+}
+
+// mergeOptions overlays options onto a copy of base, the way the package service merges an
+// update's options onto those the service was installed with.
+func mergeOptions(base, overlay map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		baseNested, baseOK := merged[key].(map[string]interface{})
+		overlayNested, overlayOK := value.(map[string]interface{})
+		if baseOK && overlayOK {
+			merged[key] = mergeOptions(baseNested, overlayNested)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// serviceOptions returns the flattened options of the service: from an options file when one is
+// given, which also allows checking a configuration before it is installed, or else as they
+// are resolved by the package service for the running instance.
+func serviceOptions(optionsFile string) (map[string]interface{}, error) {
+	var options map[string]interface{}
+	var err error
+	if len(optionsFile) != 0 {
+		options, err = readOptionsFile(optionsFile)
+	} else {
+		options, err = describeServiceOptions()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return flattenOptions(options), nil
+}
+
+// optionString returns an option as a string, or the fallback when it is unset or empty.
+func optionString(options map[string]interface{}, path, fallback string) string {
+	value, ok := options[path]
+	if !ok || optionIsEmpty(value) {
+		return fallback
+	}
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// optionFloat returns a numeric option from flattened options, or the fallback when it is unset.
+func optionFloat(options map[string]interface{}, path string, fallback float64) (float64, error) {
+	value := optionString(options, path, "")
+	if len(value) == 0 {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Option %s is not a number: %s", path, value)
+	}
+	return f, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const nestedListsJSON = `{
+  "service": {
+    "name": "scale",
+    "ports": [80, 443],
+    "matrix": [[1, 2], [3]]
+  },
+  "workspaces": [
+    {"name": "raw", "mounts": ["/data/raw"], "broker": {"type": "host", "host_path": "/data"}},
+    {"name": "products", "mounts": [], "broker": {"type": "s3", "bucket": "products"}}
+  ]
+}`
+
+// roundTrip converts options JSON to a format and back, returning both the intermediate text and
+// the map parsed from it.
+func roundTrip(t *testing.T, input, format string) (string, map[string]interface{}) {
+	tree, err := parseOptions([]byte(input), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := formatOptions(tree, format)
+	if err != nil {
+		t.Fatalf("formatting %s: %s", format, err)
+	}
+	back, err := parseOptions(converted, format)
+	if err != nil {
+		t.Fatalf("parsing %s:\n%s\n%s", format, converted, err)
+	}
+	return string(converted), back.toMap()
+}
+
+func expectedOptions(t *testing.T, input string) map[string]interface{} {
+	tree, err := parseOptions([]byte(input), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree.toMap()
+}
+
+func TestToMapUnwrapsTreesInLists(t *testing.T) {
+	options := expectedOptions(t, nestedListsJSON)
+	workspaces, ok := options["workspaces"].([]interface{})
+	if !ok || len(workspaces) != 2 {
+		t.Fatalf("workspaces is %#v", options["workspaces"])
+	}
+	first, ok := workspaces[0].(map[string]interface{})
+	if !ok {
+		t.Fatalf("workspace is %T, expected a map", workspaces[0])
+	}
+	if broker, ok := first["broker"].(map[string]interface{}); !ok || broker["type"] != "host" {
+		t.Errorf("broker is %#v", first["broker"])
+	}
+	// what the package service is sent
+	data, err := json.Marshal(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"broker":{"host_path":"/data","type":"host"}`) {
+		t.Errorf("marshalled options lost the nested objects: %s", data)
+	}
+}
+
+func TestNestedListsRoundTrip(t *testing.T) {
+	expected := expectedOptions(t, nestedListsJSON)
+	for _, format := range optionsFormats {
+		converted, actual := roundTrip(t, nestedListsJSON, format)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s round trip changed the options\n%s\ngot      %#v\nexpected %#v", format, converted, actual, expected)
+		}
+	}
+}
+
+func TestTOMLArrayOfTables(t *testing.T) {
+	input := `
+[service]
+name = "scale"
+
+[[workspaces]]
+name = "raw"
+mounts = ["/data/raw"]
+
+[workspaces.broker]
+type = "host"
+
+[[workspaces]]
+name = "products"
+`
+	tree, err := parseOptions([]byte(input), "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"service": map[string]interface{}{"name": "scale"},
+		"workspaces": []interface{}{
+			map[string]interface{}{"name": "raw", "mounts": []interface{}{"/data/raw"}, "broker": map[string]interface{}{"type": "host"}},
+			map[string]interface{}{"name": "products"},
+		},
+	}
+	if actual := tree.toMap(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got      %#v\nexpected %#v", actual, expected)
+	}
+	converted, err := formatOptions(tree, "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(converted), "[[workspaces]]") != 2 {
+		t.Errorf("expected two [[workspaces]] tables:\n%s", converted)
+	}
+}
+
+func TestYAMLListOfMaps(t *testing.T) {
+	input := `
+# Scale options
+service:
+  name: scale # the service name
+workspaces:
+  - name: raw
+    broker:
+      type: host
+  - name: products
+`
+	tree, err := parseOptions([]byte(input), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"service": map[string]interface{}{"name": "scale"},
+		"workspaces": []interface{}{
+			map[string]interface{}{"name": "raw", "broker": map[string]interface{}{"type": "host"}},
+			map[string]interface{}{"name": "products"},
+		},
+	}
+	if actual := tree.toMap(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got      %#v\nexpected %#v", actual, expected)
+	}
+	converted, err := formatOptions(tree, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(converted), "name: scale # the service name") {
+		t.Errorf("comment was lost:\n%s", converted)
+	}
+	back, err := parseOptions(converted, "yaml")
+	if err != nil {
+		t.Fatalf("%s\n%s", converted, err)
+	}
+	if actual := back.toMap(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("YAML round trip changed the options\n%s", converted)
+	}
+}
+
+func TestFlattenOptions(t *testing.T) {
+	flat := flattenOptions(map[string]interface{}{
+		"db":   map[string]interface{}{"db-host": "pg", "db-port": int64(5432)},
+		"list": []interface{}{"a"},
+	})
+	expected := map[string]interface{}{"db.db-host": "pg", "db.db-port": int64(5432), "list": []interface{}{"a"}}
+	if !reflect.DeepEqual(flat, expected) {
+		t.Errorf("got %#v", flat)
+	}
+}
+
+// TestUpdateStartPassesYAMLOptionsAsJSON runs a YAML options file through an update start which,
+// like the default sections' one, reads its --options file as JSON.
+func TestUpdateStartPassesYAMLOptionsAsJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "options")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "options.yaml")
+	if err := ioutil.WriteFile(path, []byte("node:\n  count: 3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := kingpin.New("scale", "")
+	var optionsFile, packageVersion string
+	var submitted map[string]interface{}
+	start := app.Command("update", "").Command("start", "").Action(func(c *kingpin.ParseContext) error {
+		data, err := ioutil.ReadFile(optionsFile)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &submitted)
+	})
+	start.Flag("options", "").StringVar(&optionsFile)
+	start.Flag("package-version", "").StringVar(&packageVersion)
+	handleUpdateSection(app)
+
+	if _, err := app.Parse([]string{"update", "start", "--options", path, "--package-version", "1.1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"node": map[string]interface{}{"count": 3.0}}
+	if !reflect.DeepEqual(submitted, expected) {
+		t.Errorf("update start read %v, expected %v", submitted, expected)
+	}
+	if _, err := os.Stat(optionsFile); !os.IsNotExist(err) {
+		t.Errorf("the JSON copy %s was left behind", optionsFile)
+	}
+}
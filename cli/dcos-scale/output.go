@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// printStructured prints a command result as JSON or YAML when one of those output formats is
+// selected. It returns false for the text format, leaving the command to print it itself.
+func printStructured(value interface{}) (bool, error) {
+	switch outputFormat {
+	case "json":
+		out, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return true, err
+		}
+		fmt.Println(string(out))
+		return true, nil
+	case "yaml":
+		out, err := yaml.Marshal(value)
+		if err != nil {
+			return true, err
+		}
+		fmt.Print(string(out))
+		return true, nil
+	}
+	return false, nil
+}
+
+// printTable prints tab separated rows with aligned columns.
+func printTable(header []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(header) != 0 {
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
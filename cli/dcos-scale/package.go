@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The packaging of the service ties options to pods in two hops: marathon.json.mustache maps
+// options onto scheduler environment variables, and svc.yml renders pods from those variables.
+// When the packaging files are at hand they are parsed, otherwise the defaults below, which
+// mirror universe/ and src/main/dist/svc.yml, are used.
+
+var supportingPods = []string{"db", "logstash", "rabbitmq"}
+
+var servicePods = []string{"db", "logstash", "rabbitmq", "scheduler", "webserver"}
+
+// defaultEnvOptions maps scheduler environment variables to the options they are rendered from.
+var defaultEnvOptions = map[string][]string{
+	"FRAMEWORK_NAME":                {"service.name"},
+	"FRAMEWORK_USER":                {"service.user"},
+	"FRAMEWORK_PRINCIPAL":           {"service.service_account"},
+	"ENABLE_VIRTUAL_NETWORK":        {"service.virtual_network_enabled"},
+	"VIRTUAL_NETWORK_NAME":          {"service.virtual_network_name"},
+	"VIRTUAL_NETWORK_PLUGIN_LABELS": {"service.virtual_network_plugin_labels"},
+	"NODE_COUNT":                    {"node.count"},
+	"NODE_CPUS":                     {"node.cpus"},
+	"NODE_MEM":                      {"node.mem"},
+	"NODE_PLACEMENT":                {"node.placement_constraint"},
+	"DB_DOCKER_IMAGE":               {"resource.assets.container.docker.db"},
+	"DB_CPU":                        {"db.cpu"},
+	"DB_MEM":                        {"db.memory"},
+	"DB_HOST":                       {"db.db-host"},
+	"DB_PORT":                       {"db.db-port"},
+	"DB_NAME":                       {"db.db-name"},
+	"DB_USER":                       {"db.db-user"},
+	"DB_PASS":                       {"db.db-pass", "db.db-pass-secret"},
+	"DB_PASS_SECRET":                {"db.db-pass-secret"},
+	"LOGSTASH_DOCKER_IMAGE":         {"resource.assets.container.docker.logstash"},
+	"LOGSTASH_CPU":                  {"logging.cpu"},
+	"LOGSTASH_MEM":                  {"logging.memory"},
+	"LOGSTASH_ADDRESS":              {"logging.logstash-address"},
+	"ELASTICSEARCH_URLS":            {"logging.elasticsearch-urls"},
+	"ELASTICSEARCH_LB":              {"logging.elasticsearch-lb"},
+	"RABBITMQ_DOCKER_IMAGE":         {"resource.assets.container.docker.rabbitmq"},
+	"RABBITMQ_CPU":                  {"messaging.cpu"},
+	"RABBITMQ_MEM":                  {"messaging.mem"},
+	"BROKER_URL":                    {"messaging.broker-url", "messaging.broker-url-secret"},
+	"BROKER_URL_SECRET":             {"messaging.broker-url-secret"},
+	"SCALE_DOCKER_IMAGE":            {"resource.assets.container.docker.scale"},
+	"WEBSERVER_CPU":                 {"webserver.cpu"},
+	"WEBSERVER_MEM":                 {"webserver.memory"},
+}
+
+var placementEnv = []string{"NODE_PLACEMENT", "ENABLE_VIRTUAL_NETWORK", "VIRTUAL_NETWORK_NAME", "VIRTUAL_NETWORK_PLUGIN_LABELS"}
+
+// defaultPodEnv lists the environment variables each pod in svc.yml is rendered from.
+var defaultPodEnv = map[string][]string{
+	"db":        append([]string{"DB_DOCKER_IMAGE", "DB_CPU", "DB_MEM", "DB_NAME", "DB_USER", "DB_PASS", "DB_PASS_SECRET"}, placementEnv...),
+	"logstash":  append([]string{"LOGSTASH_DOCKER_IMAGE", "LOGSTASH_CPU", "LOGSTASH_MEM", "ELASTICSEARCH_URLS", "ELASTICSEARCH_LB"}, placementEnv...),
+	"rabbitmq":  append([]string{"RABBITMQ_DOCKER_IMAGE", "RABBITMQ_CPU", "RABBITMQ_MEM"}, placementEnv...),
+	"scheduler": append([]string{"SCALE_DOCKER_IMAGE", "NODE_COUNT", "NODE_CPUS", "NODE_MEM", "BROKER_URL", "BROKER_URL_SECRET"}, placementEnv...),
+	"webserver": append([]string{"SCALE_DOCKER_IMAGE", "WEBSERVER_CPU", "WEBSERVER_MEM"}, placementEnv...),
+}
+
+// defaultPhaseToggles maps the environment variables which switch supporting phases of the
+// scale-deploy plan on or off to the phase they control.
+var defaultPhaseToggles = map[string]string{
+	"DB_HOST":          "db-deploy",
+	"LOGSTASH_ADDRESS": "logstash-deploy",
+	"BROKER_URL":       "rabbitmq-deploy",
+}
+
+// packaging is one version of the service's packaging files.
+type packaging struct {
+	Version string
+	// Config is the options schema from config.json.
+	Config map[string]interface{}
+	// Resource is resource.json, may be nil.
+	Resource map[string]interface{}
+	// EnvOptions maps scheduler env to options, from marathon.json.mustache when available.
+	EnvOptions map[string][]string
+	// PodEnv maps pods to the scheduler env they use, from svc.yml when available.
+	PodEnv map[string][]string
+	// PodTemplates holds each pod's section of svc.yml, when available.
+	PodTemplates map[string]string
+}
+
+// loadPackagingDir reads config.json and, when present, marathon.json.mustache, resource.json and
+// svc.yml from a directory laid out like universe/.
+func loadPackagingDir(dir string) (*packaging, error) {
+	pkg := &packaging{Version: filepath.Base(dir), EnvOptions: defaultEnvOptions, PodEnv: defaultPodEnv}
+	if err := readJSONFile(filepath.Join(dir, "config.json"), &pkg.Config); err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(filepath.Join(dir, "resource.json"), &pkg.Resource); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if mustache, err := ioutil.ReadFile(filepath.Join(dir, "marathon.json.mustache")); err == nil {
+		pkg.EnvOptions = parseMarathonEnv(mustache)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, name := range []string{"svc.yml", filepath.Join("dist", "svc.yml")} {
+		if template, err := ioutil.ReadFile(filepath.Join(dir, name)); err == nil {
+			pkg.PodTemplates = splitPodTemplates(template)
+			pkg.PodEnv = map[string][]string{}
+			for pod, section := range pkg.PodTemplates {
+				pkg.PodEnv[pod] = mustacheRefs(section)
+			}
+			break
+		}
+	}
+	return pkg, nil
+}
+
+// loadPackaging reads a version of the packaging from a directory when given one, from the
+// per-version directories under repoDir, or else from the cluster's package service.
+func loadPackaging(packageName, repoDir, version string) (*packaging, error) {
+	if info, err := os.Stat(version); err == nil && info.IsDir() {
+		return loadPackagingDir(version)
+	}
+	if len(repoDir) != 0 {
+		pkg, err := loadPackagingDir(filepath.Join(repoDir, version))
+		if err != nil {
+			return nil, err
+		}
+		pkg.Version = version
+		return pkg, nil
+	}
+	return loadPackagingCosmos(packageName, version)
+}
+
+// loadPackagingCosmos fetches a published version of a package from the cluster's package service.
+func loadPackagingCosmos(packageName, version string) (*packaging, error) {
+	payload, err := json.Marshal(map[string]string{"packageName": packageName, "packageVersion": version})
+	if err != nil {
+		return nil, err
+	}
+	body, err := doRequestHeaders("POST", dcosURL()+"/package/describe", payload, map[string]string{
+		"Content-Type": "application/vnd.dcos.package.describe-request+json;charset=utf-8;version=v1",
+		"Accept":       "application/vnd.dcos.package.describe-response+json;charset=utf-8;version=v2",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe %s %s: %s", packageName, version, err)
+	}
+	var response struct {
+		Package struct {
+			Config   map[string]interface{} `json:"config"`
+			Resource map[string]interface{} `json:"resource"`
+			Marathon struct {
+				V2AppMustacheTemplate string `json:"v2AppMustacheTemplate"`
+			} `json:"marathon"`
+		} `json:"package"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("Failed to parse description of %s %s: %s", packageName, version, err)
+	}
+	pkg := &packaging{
+		Version:    version,
+		Config:     response.Package.Config,
+		Resource:   response.Package.Resource,
+		EnvOptions: defaultEnvOptions,
+		PodEnv:     defaultPodEnv,
+	}
+	if len(response.Package.Marathon.V2AppMustacheTemplate) != 0 {
+		mustache, err := base64.StdEncoding.DecodeString(response.Package.Marathon.V2AppMustacheTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode marathon template of %s %s: %s", packageName, version, err)
+		}
+		pkg.EnvOptions = parseMarathonEnv(mustache)
+	}
+	return pkg, nil
+}
+
+func readJSONFile(path string, target interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("Failed to parse %s: %s", path, err)
+	}
+	return nil
+}
+
+var (
+	marathonEnvEntry = regexp.MustCompile(`"([A-Z][A-Z0-9_]*)"\s*:\s*("(?:[^"\\]|\\.)*"|[^,}\n]*)`)
+	mustacheTag      = regexp.MustCompile(`{{[{#^/&]?\s*([A-Za-z0-9_.\-]+)\s*}?}}`)
+)
+
+// parseMarathonEnv finds the env entries of a marathon.json.mustache and the options each is
+// rendered from, eg "DB_CPU": "{{db.cpu}}". An entry may be given once per section it is rendered
+// in, eg {{^db.db-pass-secret}}"DB_PASS": "{{db.db-pass}}",{{/db.db-pass-secret}}, so the options
+// of its line and of its other entries all count.
+func parseMarathonEnv(mustache []byte) map[string][]string {
+	lines := map[string][]string{}
+	for _, match := range marathonEnvEntry.FindAllSubmatchIndex(mustache, -1) {
+		start := bytes.LastIndexByte(mustache[:match[0]], '\n') + 1
+		end := bytes.IndexByte(mustache[match[1]:], '\n')
+		if end < 0 {
+			end = len(mustache)
+		} else {
+			end += match[1]
+		}
+		name := string(mustache[match[2]:match[3]])
+		lines[name] = append(lines[name], string(mustache[start:end]))
+	}
+	env := map[string][]string{}
+	for name, text := range lines {
+		env[name] = mustacheRefs(strings.Join(text, "\n"))
+	}
+	return env
+}
+
+func mustacheRefs(text string) []string {
+	seen := map[string]bool{}
+	refs := []string{}
+	for _, match := range mustacheTag.FindAllStringSubmatch(text, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			refs = append(refs, match[1])
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// splitPodTemplates returns the text of each pod under the top level "pods:" of a svc.yml
+// template. The template is not valid YAML until rendered, so it is split by indentation.
+func splitPodTemplates(template []byte) map[string]string {
+	pods := map[string]string{}
+	inPods := false
+	podIndent := -1
+	current := ""
+	var section bytes.Buffer
+	flush := func() {
+		if len(current) != 0 {
+			pods[current] = section.String()
+		}
+		section.Reset()
+		current = ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(template))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent == 0 {
+			flush()
+			inPods = strings.HasPrefix(trimmed, "pods:")
+			podIndent = -1
+			continue
+		}
+		if !inPods {
+			continue
+		}
+		if podIndent < 0 {
+			podIndent = indent
+		}
+		if indent == podIndent && strings.HasSuffix(trimmed, ":") {
+			flush()
+			current = strings.TrimSuffix(trimmed, ":")
+			continue
+		}
+		section.WriteString(line)
+		section.WriteString("\n")
+	}
+	flush()
+	return pods
+}
+
+// schemaOption is one leaf of the config.json options schema.
+type schemaOption struct {
+	Path        string
+	Type        string
+	Default     interface{}
+	HasDefault  bool
+	Description string
+}
+
+// flattenSchema returns the leaf options of a config.json schema keyed by dotted path.
+func flattenSchema(schema map[string]interface{}) map[string]*schemaOption {
+	options := map[string]*schemaOption{}
+	var walk func(node map[string]interface{}, prefix string)
+	walk = func(node map[string]interface{}, prefix string) {
+		properties, _ := node["properties"].(map[string]interface{})
+		for name, raw := range properties {
+			property, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path := prefix + name
+			if _, nested := property["properties"]; nested {
+				walk(property, path+".")
+				continue
+			}
+			option := &schemaOption{Path: path}
+			option.Type, _ = property["type"].(string)
+			if _, isEnum := property["enum"]; isEnum && len(option.Type) == 0 {
+				option.Type = "enum"
+			}
+			option.Default, option.HasDefault = property["default"]
+			option.Description, _ = property["description"].(string)
+			options[path] = option
+		}
+	}
+	walk(schema, "")
+	return options
+}
+
+// dockerImages returns the docker image assets of a resource.json keyed by asset name.
+func dockerImages(resource map[string]interface{}) map[string]string {
+	images := map[string]string{}
+	assets, _ := resource["assets"].(map[string]interface{})
+	container, _ := assets["container"].(map[string]interface{})
+	docker, _ := container["docker"].(map[string]interface{})
+	for name, image := range docker {
+		if s, ok := image.(string); ok {
+			images[name] = s
+		}
+	}
+	return images
+}
+
+// podsUsingEnv returns the pods whose definition is rendered from any of the given env vars.
+func podsUsingEnv(podEnv map[string][]string, env map[string]bool) []string {
+	pods := []string{}
+	for pod, vars := range podEnv {
+		for _, name := range vars {
+			if env[name] {
+				pods = append(pods, pod)
+				break
+			}
+		}
+	}
+	sort.Strings(pods)
+	return pods
+}
+
+// envForOptions returns the env vars which are rendered from any of the given options.
+func envForOptions(envOptions map[string][]string, options map[string]bool) map[string]bool {
+	env := map[string]bool{}
+	for name, refs := range envOptions {
+		for _, ref := range refs {
+			if options[ref] {
+				env[name] = true
+			}
+		}
+	}
+	return env
+}
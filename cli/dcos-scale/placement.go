@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// node.placement_constraint is rendered verbatim into the placement of every pod in svc.yml, where
+// the scheduler parses it as a Marathon-style constraint. It takes either the string form
+//
+//   hostname:UNIQUE,rack_id:GROUP_BY:3,hostname:LIKE:10.0.0.[1-3]
+//
+// or the JSON form [["hostname", "UNIQUE"], ["rack_id", "GROUP_BY", "3"]]. The scheduler applies
+// it to each pod type separately, eg UNIQUE keeps the scheduler pods apart from each other but not
+// from the webserver.
+
+var placementOperators = []string{"UNIQUE", "CLUSTER", "GROUP_BY", "LIKE", "UNLIKE", "MAX_PER"}
+
+// placementRule is one field:OPERATOR[:value] constraint.
+type placementRule struct {
+	Field    string
+	Operator string
+	Value    string
+	pattern  *regexp.Regexp
+	count    int
+}
+
+func (r *placementRule) String() string {
+	if len(r.Value) == 0 {
+		return r.Field + ":" + r.Operator
+	}
+	return r.Field + ":" + r.Operator + ":" + r.Value
+}
+
+// parsePlacementConstraint parses a constraint in either form. An empty constraint has no rules.
+func parsePlacementConstraint(constraint string) ([]*placementRule, error) {
+	constraint = strings.TrimSpace(constraint)
+	if len(constraint) == 0 {
+		return nil, nil
+	}
+	var parts [][]string
+	if strings.HasPrefix(constraint, "[") {
+		if err := json.Unmarshal([]byte(constraint), &parts); err != nil {
+			// a single constraint may be given without the outer list
+			var single []string
+			if err := json.Unmarshal([]byte(constraint), &single); err != nil {
+				return nil, fmt.Errorf("Invalid JSON placement constraint: %s", err)
+			}
+			parts = [][]string{single}
+		}
+	} else {
+		for _, rule := range strings.Split(constraint, ",") {
+			parts = append(parts, strings.SplitN(strings.TrimSpace(rule), ":", 3))
+		}
+	}
+	rules := []*placementRule{}
+	for _, part := range parts {
+		rule, err := parsePlacementRule(part)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parsePlacementRule(part []string) (*placementRule, error) {
+	text := strings.Join(part, ":")
+	if len(part) < 2 || len(strings.TrimSpace(part[0])) == 0 {
+		return nil, fmt.Errorf("Invalid placement constraint '%s': expected field:OPERATOR[:value]", text)
+	}
+	if len(part) > 3 {
+		return nil, fmt.Errorf("Invalid placement constraint '%s': too many parts", text)
+	}
+	rule := &placementRule{Field: strings.TrimSpace(part[0]), Operator: strings.TrimSpace(part[1])}
+	if len(part) == 3 {
+		rule.Value = part[2]
+	}
+	if !containsString(placementOperators, rule.Operator) {
+		if upper := strings.ToUpper(rule.Operator); containsString(placementOperators, upper) {
+			return nil, fmt.Errorf("Invalid placement constraint '%s': operators are upper case, did you mean %s?", text, upper)
+		}
+		return nil, fmt.Errorf("Invalid placement constraint '%s': unknown operator %s, expected one of %s",
+			text, rule.Operator, strings.Join(placementOperators, ", "))
+	}
+	switch rule.Operator {
+	case "UNIQUE":
+		if len(rule.Value) != 0 {
+			return nil, fmt.Errorf("Invalid placement constraint '%s': UNIQUE takes no value", text)
+		}
+	case "LIKE", "UNLIKE":
+		if len(rule.Value) == 0 {
+			return nil, fmt.Errorf("Invalid placement constraint '%s': %s needs a regular expression", text, rule.Operator)
+		}
+		if _, err := regexp.Compile(rule.Value); err != nil {
+			return nil, fmt.Errorf("Invalid placement constraint '%s': %s", text, err)
+		}
+		// the pattern has to match the whole value
+		rule.pattern = regexp.MustCompile("^(?:" + rule.Value + ")$")
+	case "MAX_PER", "GROUP_BY":
+		if len(rule.Value) == 0 {
+			if rule.Operator == "MAX_PER" {
+				return nil, fmt.Errorf("Invalid placement constraint '%s': MAX_PER needs a count", text)
+			}
+			break
+		}
+		count, err := strconv.Atoi(rule.Value)
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("Invalid placement constraint '%s': %s needs a positive count, not %s", text, rule.Operator, rule.Value)
+		}
+		rule.count = count
+	}
+	return rule, nil
+}
+
+// allows returns whether the rule lets another pod instance land on the agent, given the agents
+// the instances placed so far are on and the agents the pod could be placed on at all.
+func (r *placementRule) allows(agent *mesosAgent, placed []*mesosAgent, agents []*mesosAgent) bool {
+	value, ok := agent.field(r.Field)
+	counts := map[string]int{}
+	for _, other := range placed {
+		if otherValue, ok := other.field(r.Field); ok {
+			counts[otherValue]++
+		}
+	}
+	switch r.Operator {
+	case "UNIQUE":
+		return ok && counts[value] == 0
+	case "CLUSTER":
+		if len(r.Value) != 0 {
+			return ok && value == r.Value
+		}
+		if len(placed) == 0 {
+			return ok
+		}
+		first, firstOK := placed[0].field(r.Field)
+		return ok && firstOK && value == first
+	case "LIKE":
+		return ok && r.pattern.MatchString(value)
+	case "UNLIKE":
+		return !ok || !r.pattern.MatchString(value)
+	case "MAX_PER":
+		return ok && counts[value] < r.count
+	case "GROUP_BY":
+		if !ok {
+			return false
+		}
+		// spread evenly: only values with the fewest instances so far take the next one
+		values := map[string]bool{}
+		for _, other := range agents {
+			if otherValue, ok := other.field(r.Field); ok {
+				values[otherValue] = true
+			}
+		}
+		least := -1
+		for v := range values {
+			if least < 0 || counts[v] < least {
+				least = counts[v]
+			}
+		}
+		if r.count > len(values) {
+			// groups which have no agent yet count as empty
+			least = 0
+		}
+		return counts[value] <= least
+	}
+	return false
+}
+
+// placementLimit returns the most instances the rules allow on the agents, or -1 when they do not
+// limit the count.
+func placementLimit(rules []*placementRule, agents []*mesosAgent) int {
+	limit := -1
+	for _, rule := range rules {
+		values := map[string]bool{}
+		for _, agent := range agents {
+			if value, ok := agent.field(rule.Field); ok {
+				values[value] = true
+			}
+		}
+		ruleLimit := -1
+		switch rule.Operator {
+		case "UNIQUE":
+			ruleLimit = len(values)
+		case "MAX_PER":
+			ruleLimit = len(values) * rule.count
+		}
+		if ruleLimit >= 0 && (limit < 0 || ruleLimit < limit) {
+			limit = ruleLimit
+		}
+	}
+	return limit
+}
+
+// podPlacement is where one pod instance could land and where the simulation put it.
+type podPlacement struct {
+	Pod        string   `json:"pod" yaml:"pod"`
+	Candidates []string `json:"candidates" yaml:"candidates"`
+	Agent      string   `json:"agent,omitempty" yaml:"agent,omitempty"`
+	Reason     string   `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+// simulatePlacement places count instances of a pod one after the other, as the scheduler would
+// accept offers, onto the active agents. placed holds the agents of instances already running and
+// free the free resources of each agent, which are reduced by the instances placed.
+func simulatePlacement(pod string, count int, demand mesosResources, rules []*placementRule, agents []*mesosAgent, placed []*mesosAgent, free map[string]mesosResources) []podPlacement {
+	results := []podPlacement{}
+	first := len(placed)
+	for i := 0; i < count; i++ {
+		result := podPlacement{Pod: fmt.Sprintf("%s-%d", pod, first+i), Candidates: []string{}}
+		var best *mesosAgent
+		allowedByRules := 0
+		for _, agent := range agents {
+			allowed := true
+			for _, rule := range rules {
+				if !rule.allows(agent, placed, agents) {
+					allowed = false
+					break
+				}
+			}
+			if !allowed {
+				continue
+			}
+			allowedByRules++
+			if free[agent.ID].fits(demand) == 0 {
+				continue
+			}
+			result.Candidates = append(result.Candidates, agent.Hostname)
+			// land on the roomiest agent, which the offers will usually favour
+			if best == nil || free[agent.ID].fits(demand) > free[best.ID].fits(demand) {
+				best = agent
+			}
+		}
+		sort.Strings(result.Candidates)
+		if best == nil {
+			if allowedByRules == 0 {
+				result.Reason = "no agent satisfies the placement constraint"
+			} else {
+				result.Reason = fmt.Sprintf("none of the %d agents the constraint allows has %.2f cpus and %.0f MB free", allowedByRules, demand.CPUs, demand.Mem)
+			}
+			results = append(results, result)
+			continue
+		}
+		result.Agent = best.Hostname
+		free[best.ID] = free[best.ID].minus(demand)
+		placed = append(placed, best)
+		results = append(results, result)
+	}
+	return results
+}
+
+type placementHandler struct {
+	mesosState  string
+	constraint  string
+	optionsFile string
+	pods        []string
+}
+
+func (cmd *placementHandler) handleSimulate(c *kingpin.ParseContext) error {
+	state := &mesosState{}
+	if err := readJSONFile(cmd.mesosState, state); err != nil {
+		return fmt.Errorf("Failed to read Mesos state from %s: %s", cmd.mesosState, err)
+	}
+	options := map[string]interface{}{}
+	if len(cmd.optionsFile) != 0 {
+		var err error
+		if options, err = serviceOptions(cmd.optionsFile); err != nil {
+			return err
+		}
+	}
+	constraint := cmd.constraint
+	if len(constraint) == 0 {
+		constraint = optionString(options, "node.placement_constraint", "")
+	}
+	rules, err := parsePlacementConstraint(constraint)
+	if err != nil {
+		return err
+	}
+
+	agents := state.activeAgents()
+	free := map[string]mesosResources{}
+	for _, agent := range agents {
+		free[agent.ID] = agent.free()
+	}
+	pods := cmd.pods
+	if len(pods) == 0 {
+		pods = servicePods
+	}
+	results := []podPlacement{}
+	for _, pod := range pods {
+		// only the pods simulated are sized, and one which cannot be is shown with the reason
+		reservation, err := podReservationFromOptions(options, pod)
+		if err != nil {
+			results = append(results, podPlacement{Pod: pod, Candidates: []string{}, Reason: err.Error()})
+			continue
+		}
+		if reservation == nil {
+			continue
+		}
+		results = append(results, simulatePlacement(reservation.Pod, reservation.Count, reservation.Instance, rules, agents, nil, free)...)
+	}
+	if printed, err := printStructured(results); printed {
+		return err
+	}
+	if len(rules) == 0 {
+		client.PrintMessage("No placement constraint, every agent with enough free resources is a candidate.")
+	} else {
+		ruleTexts := []string{}
+		for _, rule := range rules {
+			ruleTexts = append(ruleTexts, rule.String())
+		}
+		client.PrintMessage("Placement constraint: %s", strings.Join(ruleTexts, ", "))
+	}
+	rows := [][]string{}
+	unplaced := 0
+	for _, result := range results {
+		landing := result.Agent
+		if len(landing) == 0 {
+			landing = "-"
+			unplaced++
+		}
+		candidates := strings.Join(result.Candidates, ", ")
+		if len(result.Reason) != 0 {
+			candidates = result.Reason
+		}
+		rows = append(rows, []string{result.Pod, landing, candidates})
+	}
+	printTable([]string{"POD", "LANDS ON", "COULD LAND ON"}, rows)
+	if unplaced != 0 {
+		return fmt.Errorf("%d of %d pods cannot be placed", unplaced, len(results))
+	}
+	return nil
+}
+
+func handlePlacementSection(app *kingpin.Application) {
+	cmd := &placementHandler{}
+	placement := app.Command("placement", "Work with the node placement constraint")
+
+	simulate := placement.Command("simulate", "Show where each pod could land under the placement constraint").Action(cmd.handleSimulate)
+	simulate.Flag("mesos-state", "Mesos master state JSON file listing the agents").Required().StringVar(&cmd.mesosState)
+	simulate.Flag("constraint", "Constraint to simulate, defaults to node.placement_constraint from --options").StringVar(&cmd.constraint)
+	simulate.Flag("options", "Options file to take the constraint, pod counts and resources from").StringVar(&cmd.optionsFile)
+	simulate.Flag("pod", "Pod types to simulate, defaults to all").EnumsVar(&cmd.pods, servicePods...)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePlacementConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		// rules are the parsed rules as strings, error a part of the expected error
+		rules []string
+		error string
+	}{
+		{"", nil, ""},
+		{"hostname:UNIQUE", []string{"hostname:UNIQUE"}, ""},
+		{"hostname:UNIQUE, rack_id:GROUP_BY:3,hostname:LIKE:10.0.0.[1-3]",
+			[]string{"hostname:UNIQUE", "rack_id:GROUP_BY:3", "hostname:LIKE:10.0.0.[1-3]"}, ""},
+		{"hostname:LIKE:a:b", []string{"hostname:LIKE:a:b"}, ""},
+		{`[["hostname", "UNIQUE"], ["rack_id", "GROUP_BY", "3"]]`, []string{"hostname:UNIQUE", "rack_id:GROUP_BY:3"}, ""},
+		{`["hostname", "MAX_PER", "2"]`, []string{"hostname:MAX_PER:2"}, ""},
+		{`[["hostname", "UNIQUE"]`, nil, "Invalid JSON placement constraint"},
+		{"hostname:unique", nil, "did you mean UNIQUE?"},
+		{`[["rack_id", "group_by"]]`, nil, "did you mean GROUP_BY?"},
+		{"hostname:NEAR", nil, "unknown operator NEAR"},
+		{"hostname", nil, "expected field:OPERATOR[:value]"},
+		{":UNIQUE", nil, "expected field:OPERATOR[:value]"},
+		{`[["hostname", "LIKE", "a", "b"]]`, nil, "too many parts"},
+		{"hostname:UNIQUE:1", nil, "UNIQUE takes no value"},
+		{"hostname:MAX_PER", nil, "MAX_PER needs a count"},
+		{"hostname:MAX_PER:0", nil, "MAX_PER needs a positive count, not 0"},
+		{"hostname:MAX_PER:two", nil, "MAX_PER needs a positive count, not two"},
+		{"rack_id:GROUP_BY:-1", nil, "GROUP_BY needs a positive count"},
+		{"hostname:LIKE", nil, "LIKE needs a regular expression"},
+		{"hostname:UNLIKE:10.0.0.[1-", nil, "missing closing ]"},
+	}
+	for _, test := range tests {
+		rules, err := parsePlacementConstraint(test.constraint)
+		if len(test.error) != 0 {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("%q returned %v, expected an error with %q", test.constraint, err, test.error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q returned %s", test.constraint, err)
+			continue
+		}
+		var texts []string
+		for _, rule := range rules {
+			texts = append(texts, rule.String())
+		}
+		if !reflect.DeepEqual(texts, test.rules) {
+			t.Errorf("%q parsed as %v, expected %v", test.constraint, texts, test.rules)
+		}
+	}
+}
+
+func TestParsePlacementRuleCount(t *testing.T) {
+	tests := []struct {
+		part  []string
+		count int
+	}{
+		{[]string{"hostname", "MAX_PER", "2"}, 2},
+		{[]string{"rack_id", "GROUP_BY", "3"}, 3},
+		{[]string{"rack_id", "GROUP_BY"}, 0},
+	}
+	for _, test := range tests {
+		rule, err := parsePlacementRule(test.part)
+		if err != nil {
+			t.Errorf("%v returned %s", test.part, err)
+			continue
+		}
+		if rule.count != test.count {
+			t.Errorf("%v has count %d, expected %d", test.part, rule.count, test.count)
+		}
+	}
+}
+
+// placementAgents are four agents, three of them on two racks.
+var placementAgents = []*mesosAgent{
+	{ID: "a1", Hostname: "10.0.0.1", Attributes: map[string]interface{}{"rack_id": "r1"}},
+	{ID: "a2", Hostname: "10.0.0.2", Attributes: map[string]interface{}{"rack_id": "r1"}},
+	{ID: "a3", Hostname: "10.0.0.3", Attributes: map[string]interface{}{"rack_id": "r2"}},
+	{ID: "a4", Hostname: "10.0.0.4"},
+}
+
+func placementRuleFor(t *testing.T, constraint string) *placementRule {
+	rules, err := parsePlacementConstraint(constraint)
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("%q returned %v, %v", constraint, rules, err)
+	}
+	return rules[0]
+}
+
+func TestPlacementRuleAllows(t *testing.T) {
+	a1, a2, a3, a4 := placementAgents[0], placementAgents[1], placementAgents[2], placementAgents[3]
+	tests := []struct {
+		constraint string
+		placed     []*mesosAgent
+		// allowed are the agents the next instance may land on
+		allowed []string
+	}{
+		{"hostname:UNIQUE", nil, []string{"a1", "a2", "a3", "a4"}},
+		{"hostname:UNIQUE", []*mesosAgent{a1, a3}, []string{"a2", "a4"}},
+		{"rack_id:UNIQUE", []*mesosAgent{a1}, []string{"a3"}},
+		{"rack_id:CLUSTER:r2", nil, []string{"a3"}},
+		{"rack_id:CLUSTER", nil, []string{"a1", "a2", "a3"}},
+		{"rack_id:CLUSTER", []*mesosAgent{a2}, []string{"a1", "a2"}},
+		{"hostname:LIKE:10.0.0.[1-2]", nil, []string{"a1", "a2"}},
+		// the pattern has to match the whole value
+		{"hostname:LIKE:10.0.0", nil, nil},
+		{"rack_id:UNLIKE:r1", nil, []string{"a3", "a4"}},
+		{"rack_id:MAX_PER:2", []*mesosAgent{a1, a2}, []string{"a3"}},
+		{"hostname:MAX_PER:1", []*mesosAgent{a4}, []string{"a1", "a2", "a3"}},
+		// GROUP_BY spreads the instances across the racks
+		{"rack_id:GROUP_BY", nil, []string{"a1", "a2", "a3"}},
+		{"rack_id:GROUP_BY", []*mesosAgent{a1}, []string{"a3"}},
+		{"rack_id:GROUP_BY", []*mesosAgent{a1, a3}, []string{"a1", "a2", "a3"}},
+		// with more groups expected than have agents, every rack with an instance waits
+		{"rack_id:GROUP_BY:3", []*mesosAgent{a3}, []string{"a1", "a2"}},
+		{"rack_id:GROUP_BY:3", []*mesosAgent{a1, a3}, nil},
+	}
+	for _, test := range tests {
+		rule := placementRuleFor(t, test.constraint)
+		var allowed []string
+		for _, agent := range placementAgents {
+			if rule.allows(agent, test.placed, placementAgents) {
+				allowed = append(allowed, agent.ID)
+			}
+		}
+		if !reflect.DeepEqual(allowed, test.allowed) {
+			t.Errorf("%s with %d placed allows %v, expected %v", test.constraint, len(test.placed), allowed, test.allowed)
+		}
+	}
+}
+
+func TestPlacementLimit(t *testing.T) {
+	tests := []struct {
+		constraint string
+		limit      int
+	}{
+		{"", -1},
+		{"hostname:UNIQUE", 4},
+		{"rack_id:UNIQUE", 2},
+		{"rack_id:MAX_PER:3", 6},
+		{"hostname:UNIQUE,rack_id:MAX_PER:1", 2},
+		{"rack_id:GROUP_BY:2", -1},
+		{"hostname:LIKE:10.0.0.[1-2]", -1},
+	}
+	for _, test := range tests {
+		rules, err := parsePlacementConstraint(test.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limit := placementLimit(rules, placementAgents); limit != test.limit {
+			t.Errorf("%q limits to %d, expected %d", test.constraint, limit, test.limit)
+		}
+	}
+}
+
+func TestSimulateSpreadsGroups(t *testing.T) {
+	free := map[string]mesosResources{}
+	for _, agent := range placementAgents {
+		free[agent.ID] = mesosResources{CPUs: 4, Mem: 8192}
+	}
+	rules, err := parsePlacementConstraint("rack_id:GROUP_BY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	racks := map[string]int{}
+	for _, result := range simulatePlacement("scheduler", 4, mesosResources{CPUs: 1, Mem: 1024}, rules, placementAgents, nil, free) {
+		for _, agent := range placementAgents {
+			if agent.Hostname == result.Agent {
+				racks[agent.Attributes["rack_id"].(string)]++
+			}
+		}
+	}
+	if expected := map[string]int{"r1": 2, "r2": 2}; !reflect.DeepEqual(racks, expected) {
+		t.Errorf("instances per rack are %v, expected %v", racks, expected)
+	}
+}
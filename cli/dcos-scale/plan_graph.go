@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var graphFormats = []string{"dot", "mermaid", "ascii"}
+
+// planGraph is the dependency graph of a plan: its nodes are steps, or whole phases when their
+// steps are unknown, and an edge means the target waits for the source.
+type planGraph struct {
+	root  *planNode
+	nodes []*graphNode
+	edges [][2]*graphNode
+	// members maps the phases of the tree to the nodes they hold.
+	members map[*planNode][]*graphNode
+}
+
+type graphNode struct {
+	ID     string
+	Label  string
+	Status string
+}
+
+func newPlanGraph(root *planNode) *planGraph {
+	g := &planGraph{root: root, members: map[*planNode][]*graphNode{}}
+	g.add(root)
+	return g
+}
+
+func (g *planGraph) newNode(label, status string) *graphNode {
+	node := &graphNode{ID: fmt.Sprintf("n%d", len(g.nodes)), Label: label, Status: status}
+	g.nodes = append(g.nodes, node)
+	return node
+}
+
+// add adds a phase and returns the nodes which start it and those which finish it. A serial
+// phase chains its members one after the other, a parallel phase starts them all together.
+func (g *planGraph) add(phase *planNode) (entries, exits []*graphNode) {
+	type member struct {
+		entries, exits []*graphNode
+	}
+	var members []member
+	for _, step := range phase.Steps {
+		node := g.newNode(step.Name, step.Status)
+		g.members[phase] = append(g.members[phase], node)
+		members = append(members, member{[]*graphNode{node}, []*graphNode{node}})
+	}
+	for _, child := range phase.Children {
+		childEntries, childExits := g.add(child)
+		members = append(members, member{childEntries, childExits})
+	}
+	if len(members) == 0 && phase != g.root {
+		node := g.newNode(phase.Name, phase.Status)
+		g.members[phase] = append(g.members[phase], node)
+		return []*graphNode{node}, []*graphNode{node}
+	}
+	if phase.Strategy == "parallel" {
+		for _, m := range members {
+			entries = append(entries, m.entries...)
+			exits = append(exits, m.exits...)
+		}
+		return entries, exits
+	}
+	for i, m := range members {
+		if i == 0 {
+			entries = m.entries
+		} else {
+			for _, from := range members[i-1].exits {
+				for _, to := range m.entries {
+					g.edges = append(g.edges, [2]*graphNode{from, to})
+				}
+			}
+		}
+		exits = m.exits
+	}
+	return entries, exits
+}
+
+// stages groups nodes by the length of the longest chain of nodes they wait for, so that each
+// stage only holds nodes which may run at the same time.
+func (g *planGraph) stages() [][]*graphNode {
+	depth := map[*graphNode]int{}
+	for changed := true; changed; {
+		changed = false
+		for _, edge := range g.edges {
+			if depth[edge[1]] < depth[edge[0]]+1 {
+				depth[edge[1]] = depth[edge[0]] + 1
+				changed = true
+			}
+		}
+	}
+	var stages [][]*graphNode
+	for _, node := range g.nodes {
+		for len(stages) <= depth[node] {
+			stages = append(stages, nil)
+		}
+		stages[depth[node]] = append(stages[depth[node]], node)
+	}
+	return stages
+}
+
+type statusStyle struct {
+	fill  string
+	class string
+}
+
+func styleOf(status string) statusStyle {
+	switch status {
+	case "COMPLETE":
+		return statusStyle{"#8fd18f", "complete"}
+	case "ERROR":
+		return statusStyle{"#f28b82", "error"}
+	case "PENDING", "WAITING", "":
+		return statusStyle{"#e0e0e0", "pending"}
+	}
+	return statusStyle{"#8ab4f8", "inprogress"}
+}
+
+func quoteLabel(label string) string {
+	return strings.Replace(strings.Replace(label, `\`, `\\`, -1), `"`, `\"`, -1)
+}
+
+func (g *planGraph) writeDot(out io.Writer) {
+	fmt.Fprintf(out, "digraph \"%s\" {\n", quoteLabel(g.root.Name))
+	fmt.Fprintf(out, "  label=\"%s (%s) %s\";\n  labelloc=t;\n  node [shape=box, style=filled];\n", quoteLabel(g.root.Name), g.root.Strategy, g.root.Status)
+	cluster := 0
+	var writePhase func(phase *planNode, indent string)
+	writePhase = func(phase *planNode, indent string) {
+		for _, node := range g.members[phase] {
+			fmt.Fprintf(out, "%s%s [label=\"%s\\n%s\", fillcolor=\"%s\"];\n", indent, node.ID, quoteLabel(node.Label), node.Status, styleOf(node.Status).fill)
+		}
+		for _, child := range phase.Children {
+			fmt.Fprintf(out, "%ssubgraph cluster_%d {\n", indent, cluster)
+			cluster++
+			fmt.Fprintf(out, "%s  label=\"%s (%s) %s\";\n", indent, quoteLabel(child.Name), child.Strategy, child.Status)
+			writePhase(child, indent+"  ")
+			fmt.Fprintf(out, "%s}\n", indent)
+		}
+	}
+	writePhase(g.root, "  ")
+	for _, edge := range g.edges {
+		fmt.Fprintf(out, "  %s -> %s;\n", edge[0].ID, edge[1].ID)
+	}
+	fmt.Fprintln(out, "}")
+}
+
+func (g *planGraph) writeMermaid(out io.Writer) {
+	fmt.Fprintln(out, "flowchart TD")
+	var writePhase func(phase *planNode, indent string)
+	cluster := 0
+	writePhase = func(phase *planNode, indent string) {
+		for _, node := range g.members[phase] {
+			fmt.Fprintf(out, "%s%s[\"%s<br/>%s\"]\n", indent, node.ID, strings.Replace(node.Label, `"`, "#quot;", -1), node.Status)
+		}
+		for _, child := range phase.Children {
+			fmt.Fprintf(out, "%ssubgraph p%d[\"%s (%s)\"]\n", indent, cluster, strings.Replace(child.Name, `"`, "#quot;", -1), child.Strategy)
+			cluster++
+			writePhase(child, indent+"  ")
+			fmt.Fprintf(out, "%send\n", indent)
+		}
+	}
+	writePhase(g.root, "  ")
+	for _, edge := range g.edges {
+		fmt.Fprintf(out, "  %s --> %s\n", edge[0].ID, edge[1].ID)
+	}
+	classes := map[string][]string{}
+	fills := map[string]string{}
+	for _, node := range g.nodes {
+		style := styleOf(node.Status)
+		classes[style.class] = append(classes[style.class], node.ID)
+		fills[style.class] = style.fill
+	}
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  classDef %s fill:%s\n", name, fills[name])
+		fmt.Fprintf(out, "  class %s %s\n", strings.Join(classes[name], ","), name)
+	}
+}
+
+func (g *planGraph) writeASCII(out io.Writer) {
+	fmt.Fprintf(out, "%s (%s) %s\n", g.root.Name, g.root.Strategy, g.root.Status)
+	stages := g.stages()
+	for i, stage := range stages {
+		labels := make([]string, 0, len(stage))
+		for _, node := range stage {
+			labels = append(labels, fmt.Sprintf("[%s %s]", node.Label, node.Status))
+		}
+		fmt.Fprintf(out, "  %s\n", strings.Join(labels, "  "))
+		if i < len(stages)-1 {
+			fmt.Fprintln(out, "      |\n      v")
+		}
+	}
+}
+
+func (g *planGraph) write(out io.Writer, format string) {
+	switch format {
+	case "dot":
+		g.writeDot(out)
+	case "mermaid":
+		g.writeMermaid(out)
+	default:
+		g.writeASCII(out)
+	}
+}
+
+type planGraphHandler struct {
+	plan    string
+	format  string
+	svcYML  string
+	offline bool
+}
+
+func (cmd *planGraphHandler) handleGraph(c *kingpin.ParseContext) error {
+	if cmd.offline && len(cmd.svcYML) == 0 {
+		return fmt.Errorf("--offline needs a rendered svc.yml passed with --svc-yml")
+	}
+	layout, err := resolvePlanLayout(cmd.plan, cmd.svcYML)
+	if err != nil {
+		return err
+	}
+	var root *planNode
+	if cmd.offline {
+		root = layoutTree(layout)
+	} else {
+		current, err := fetchPlan(cmd.plan)
+		if err != nil {
+			return err
+		}
+		root = buildPlanTree(cmd.plan, layout, current)
+	}
+	var buf bytes.Buffer
+	newPlanGraph(root).write(&buf, cmd.format)
+	_, err = os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+func handlePlanGraphSection(app *kingpin.Application) {
+	cmd := &planGraphHandler{}
+	plan := app.GetCommand("plan")
+	if plan == nil {
+		plan = app.Command("plan", "Query service plans")
+	}
+	graph := plan.Command("graph", "Render a plan as a dependency graph of its steps").Action(cmd.handleGraph)
+	graph.Arg("plan", "Name of the plan").Required().StringVar(&cmd.plan)
+	graph.Flag("format", "Graph format").Default("ascii").EnumVar(&cmd.format, graphFormats...)
+	graph.Flag("svc-yml", "Rendered svc.yml to read the plan's layout from").StringVar(&cmd.svcYML)
+	graph.Flag("offline", "Render the plan from --svc-yml alone, without querying the service").BoolVar(&cmd.offline)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// stepHistory is what the watcher has observed of a step: the scheduler only reports the
+// current status, so timings are measured from the watcher's own polls.
+type stepHistory struct {
+	status   string
+	previous string
+	changed  time.Time
+	started  time.Time
+	finished time.Time
+}
+
+type planWatcher struct {
+	name     string
+	layout   *planLayout
+	started  time.Time
+	history  map[string]*stepHistory
+	redraw   bool
+	lastDraw string
+}
+
+func newPlanWatcher(name string, layout *planLayout, redraw bool) *planWatcher {
+	return &planWatcher{
+		name:    name,
+		layout:  layout,
+		started: time.Now(),
+		history: map[string]*stepHistory{},
+		redraw:  redraw,
+	}
+}
+
+// observe records status changes of every step in the tree.
+func (w *planWatcher) observe(node *planNode, now time.Time) {
+	for _, step := range node.Steps {
+		key := node.Name + "/" + step.Name
+		history, ok := w.history[key]
+		if !ok {
+			history = &stepHistory{status: step.Status, changed: now}
+			w.history[key] = history
+		} else if history.status != step.Status {
+			history.previous = history.status
+			history.status = step.Status
+			history.changed = now
+		}
+		if history.started.IsZero() && !planIsFinished(step.Status) && step.Status != "PENDING" && step.Status != "WAITING" {
+			history.started = now
+		}
+		if history.finished.IsZero() && !history.started.IsZero() && planIsFinished(step.Status) {
+			history.finished = now
+		}
+	}
+	for _, child := range node.Children {
+		w.observe(child, now)
+	}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d - d%time.Second
+}
+
+func (w *planWatcher) render(out io.Writer, root *planNode, now time.Time) {
+	fmt.Fprintf(out, "%s (%s) %s  elapsed %s\n", root.Name, root.Strategy, root.Status, roundDuration(now.Sub(w.started)))
+	w.renderChildren(out, root, "", now)
+}
+
+func (w *planWatcher) renderChildren(out io.Writer, node *planNode, indent string, now time.Time) {
+	count := len(node.Children) + len(node.Steps)
+	i := 0
+	branch := func() (string, string) {
+		i++
+		if i == count {
+			return "└─ ", "   "
+		}
+		return "├─ ", "│  "
+	}
+	for _, child := range node.Children {
+		prefix, nextIndent := branch()
+		fmt.Fprintf(out, "%s%s%s (%s) %s\n", indent, prefix, child.Name, child.Strategy, child.Status)
+		w.renderChildren(out, child, indent+nextIndent, now)
+	}
+	for _, step := range node.Steps {
+		prefix, _ := branch()
+		line := fmt.Sprintf("%s%s%s %s", indent, prefix, step.Name, step.Status)
+		if history, ok := w.history[node.Name+"/"+step.Name]; ok {
+			if !history.started.IsZero() {
+				end := now
+				if !history.finished.IsZero() {
+					end = history.finished
+				}
+				line += fmt.Sprintf("  elapsed %s", roundDuration(end.Sub(history.started)))
+			}
+			if len(history.previous) != 0 {
+				line += fmt.Sprintf("  %s -> %s %s ago", history.previous, history.status, roundDuration(now.Sub(history.changed)))
+			} else {
+				line += fmt.Sprintf("  unchanged for %s", roundDuration(now.Sub(history.changed)))
+			}
+		}
+		fmt.Fprintln(out, line)
+	}
+}
+
+// draw prints the tree, redrawing the screen on a terminal. Elsewhere, eg when piped to a log,
+// the tree is only printed again once some status changes.
+func (w *planWatcher) draw(root *planNode, now time.Time) {
+	var buf bytes.Buffer
+	w.render(&buf, root, now)
+	if w.redraw {
+		fmt.Print("\033[H\033[2J")
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	var statuses bytes.Buffer
+	printStatuses(&statuses, root)
+	if statuses.String() != w.lastDraw {
+		w.lastDraw = statuses.String()
+		fmt.Printf("[%s]\n", now.Format(time.RFC3339))
+		os.Stdout.Write(buf.Bytes())
+	}
+}
+
+func printStatuses(out io.Writer, node *planNode) {
+	fmt.Fprintf(out, "%s=%s\n", node.Name, node.Status)
+	for _, step := range node.Steps {
+		fmt.Fprintf(out, "%s=%s\n", step.Name, step.Status)
+	}
+	for _, child := range node.Children {
+		printStatuses(out, child)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// resolvePlanLayout returns the layout of a plan from a rendered svc.yml when one is given, or
+// else from the service's own svc.yml.
+func resolvePlanLayout(name, svcYML string) (*planLayout, error) {
+	if len(svcYML) == 0 {
+		return defaultPlanLayouts[name], nil
+	}
+	layouts, err := loadPlanLayouts(svcYML)
+	if err != nil {
+		return nil, err
+	}
+	layout, ok := layouts[name]
+	if !ok {
+		return nil, fmt.Errorf("No plan named %s in %s", name, svcYML)
+	}
+	return layout, nil
+}
+
+type planWatchHandler struct {
+	plan     string
+	svcYML   string
+	interval time.Duration
+}
+
+func (cmd *planWatchHandler) handleWatch(c *kingpin.ParseContext) error {
+	layout, err := resolvePlanLayout(cmd.plan, cmd.svcYML)
+	if err != nil {
+		return err
+	}
+	watcher := newPlanWatcher(cmd.plan, layout, isTerminal(os.Stdout))
+	for {
+		current, err := fetchPlan(cmd.plan)
+		now := time.Now()
+		if isStatus(err, 404) {
+			return fmt.Errorf("No plan named %s: %s", cmd.plan, err)
+		}
+		if err != nil && !isTransientError(err) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Failed to fetch plan %s, retrying: %s\n", now.Format(time.RFC3339), cmd.plan, err)
+		} else {
+			root := buildPlanTree(cmd.plan, layout, current)
+			watcher.observe(root, now)
+			watcher.draw(root, now)
+			switch current.Status {
+			case "COMPLETE":
+				return nil
+			case "ERROR":
+				for _, planError := range current.Errors {
+					fmt.Fprintln(os.Stderr, planError)
+				}
+				return fmt.Errorf("Plan %s failed", cmd.plan)
+			}
+		}
+		time.Sleep(cmd.interval)
+	}
+}
+
+func handlePlanWatchSection(app *kingpin.Application) {
+	cmd := &planWatchHandler{}
+	plan := app.GetCommand("plan")
+	if plan == nil {
+		plan = app.Command("plan", "Query service plans")
+	}
+	watch := plan.Command("watch", "Follow a plan as a tree of phases and steps until it completes or fails").Action(cmd.handleWatch)
+	watch.Arg("plan", "Name of the plan").Default("scale-deploy").StringVar(&cmd.plan)
+	watch.Flag("svc-yml", "Rendered svc.yml to read the plan's layout from").StringVar(&cmd.svcYML)
+	watch.Flag("interval", "Time between polls").Default("2s").DurationVar(&cmd.interval)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The scheduler reports a plan as a flat list of phases, while svc.yml may nest phases, as the
+// scale-deploy plan does with its parallel supporting-deploy phase. Plans are regrouped into
+// trees following the layout from svc.yml so they can be shown the way they are declared.
+
+type planStep struct {
+	ID      string `json:"id" yaml:"id"`
+	Name    string `json:"name" yaml:"name"`
+	Status  string `json:"status" yaml:"status"`
+	Message string `json:"message" yaml:"message"`
+}
+
+type planPhase struct {
+	ID       string      `json:"id" yaml:"id"`
+	Name     string      `json:"name" yaml:"name"`
+	Status   string      `json:"status" yaml:"status"`
+	Strategy string      `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	Steps    []planStep  `json:"steps" yaml:"steps"`
+	Phases   []planPhase `json:"phases,omitempty" yaml:"phases,omitempty"`
+}
+
+type plan struct {
+	Phases []planPhase `json:"phases" yaml:"phases"`
+	Errors []string    `json:"errors" yaml:"errors"`
+	Status string      `json:"status" yaml:"status"`
+}
+
+// fetchPlan returns the current state of a plan from the scheduler.
+func fetchPlan(name string) (*plan, error) {
+	body, err := serviceRequest("GET", "v1/plans/"+name, nil, "")
+	// plans which are in progress or failed are returned along with a 503 or 417 status
+	if err != nil && !isStatus(err, 503) && !isStatus(err, 417) {
+		return nil, err
+	}
+	var result plan
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("Failed to parse plan %s: %s", name, err)
+	}
+	return &result, nil
+}
+
+// planLayout is the declared structure of a plan, from svc.yml.
+type planLayout struct {
+	Name     string
+	Strategy string
+	Pod      string
+	// Steps are the step names of a pod phase, when the pod count is known.
+	Steps  []string
+	Phases []*planLayout
+}
+
+// defaultPlanLayouts mirrors the plans of src/main/dist/svc.yml.
+var defaultPlanLayouts = map[string]*planLayout{
+	"scale-deploy": {
+		Name:     "scale-deploy",
+		Strategy: "serial",
+		Phases: []*planLayout{
+			{
+				Name:     "supporting-deploy",
+				Strategy: "parallel",
+				Phases: []*planLayout{
+					{Name: "db-deploy", Strategy: "serial", Pod: "db"},
+					{Name: "logstash-deploy", Strategy: "serial", Pod: "logstash"},
+					{Name: "rabbitmq-deploy", Strategy: "serial", Pod: "rabbitmq"},
+				},
+			},
+			{Name: "webserver-deploy", Strategy: "serial", Pod: "webserver"},
+			{Name: "scheduler-deploy", Strategy: "serial", Pod: "scheduler"},
+		},
+	},
+}
+
+func mapSliceGet(slice yaml.MapSlice, key string) interface{} {
+	for _, item := range slice {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// loadPlanLayouts reads the plans of a rendered svc.yml.
+func loadPlanLayouts(path string) (map[string]*planLayout, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec yaml.MapSlice
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s, it must be a rendered svc.yml: %s", path, err)
+	}
+	podCounts := map[string]int{}
+	pods, _ := mapSliceGet(spec, "pods").(yaml.MapSlice)
+	for _, item := range pods {
+		pod, _ := item.Value.(yaml.MapSlice)
+		if count, err := strconv.Atoi(fmt.Sprint(mapSliceGet(pod, "count"))); err == nil {
+			podCounts[fmt.Sprint(item.Key)] = count
+		}
+	}
+	layouts := map[string]*planLayout{}
+	plans, _ := mapSliceGet(spec, "plans").(yaml.MapSlice)
+	for _, item := range plans {
+		name := fmt.Sprint(item.Key)
+		body, _ := item.Value.(yaml.MapSlice)
+		layouts[name] = parsePlanLayout(name, body, podCounts)
+	}
+	return layouts, nil
+}
+
+func parsePlanLayout(name string, body yaml.MapSlice, podCounts map[string]int) *planLayout {
+	layout := &planLayout{Name: name, Strategy: "serial"}
+	if strategy, ok := mapSliceGet(body, "strategy").(string); ok {
+		layout.Strategy = strategy
+	}
+	if pod, ok := mapSliceGet(body, "pod").(string); ok {
+		layout.Pod = pod
+		tasks := []string{}
+		steps, _ := mapSliceGet(body, "steps").([]interface{})
+		for _, step := range steps {
+			// steps are declared as eg "- default: [[launch]]"
+			stepMap, _ := step.(yaml.MapSlice)
+			for _, item := range stepMap {
+				groups, _ := item.Value.([]interface{})
+				for _, group := range groups {
+					groupTasks, _ := group.([]interface{})
+					for _, task := range groupTasks {
+						tasks = append(tasks, fmt.Sprint(task))
+					}
+				}
+			}
+		}
+		for i := 0; i < podCounts[pod]; i++ {
+			layout.Steps = append(layout.Steps, fmt.Sprintf("%s-%d:[%s]", pod, i, strings.Join(tasks, ", ")))
+		}
+	}
+	phases, _ := mapSliceGet(body, "phases").(yaml.MapSlice)
+	for _, item := range phases {
+		phase, _ := item.Value.(yaml.MapSlice)
+		layout.Phases = append(layout.Phases, parsePlanLayout(fmt.Sprint(item.Key), phase, podCounts))
+	}
+	return layout
+}
+
+// planNode is a phase, or a group of phases, of a plan regrouped by its layout.
+type planNode struct {
+	Name     string      `json:"name" yaml:"name"`
+	Strategy string      `json:"strategy" yaml:"strategy"`
+	Status   string      `json:"status" yaml:"status"`
+	Steps    []planStep  `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Children []*planNode `json:"phases,omitempty" yaml:"phases,omitempty"`
+}
+
+// buildPlanTree arranges the phases reported by the scheduler according to the layout. Phases
+// which are not in the layout are kept at the top level, and phases of the layout which were
+// not rendered, eg a supporting service configured externally, are left out.
+func buildPlanTree(name string, layout *planLayout, current *plan) *planNode {
+	phases := map[string]planPhase{}
+	var order []string
+	var collect func(list []planPhase)
+	collect = func(list []planPhase) {
+		for _, phase := range list {
+			phases[phase.Name] = phase
+			order = append(order, phase.Name)
+			collect(phase.Phases)
+		}
+	}
+	collect(current.Phases)
+
+	used := map[string]bool{}
+	var build func(l *planLayout) *planNode
+	build = func(l *planLayout) *planNode {
+		node := &planNode{Name: l.Name, Strategy: l.Strategy}
+		if phase, ok := phases[l.Name]; ok && len(l.Phases) == 0 {
+			used[l.Name] = true
+			node.Status = phase.Status
+			node.Steps = phase.Steps
+			return node
+		}
+		for _, child := range l.Phases {
+			if childNode := build(child); childNode != nil {
+				node.Children = append(node.Children, childNode)
+			}
+		}
+		if len(node.Children) == 0 {
+			return nil
+		}
+		if phase, ok := phases[l.Name]; ok {
+			used[l.Name] = true
+			node.Status = phase.Status
+		} else {
+			node.Status = aggregateStatus(node.Children)
+		}
+		return node
+	}
+
+	root := &planNode{Name: name, Strategy: "serial", Status: current.Status}
+	if layout != nil {
+		root.Strategy = layout.Strategy
+		for _, child := range layout.Phases {
+			if node := build(child); node != nil {
+				root.Children = append(root.Children, node)
+			}
+		}
+	}
+	for _, phaseName := range order {
+		if !used[phaseName] && len(phases[phaseName].Phases) == 0 {
+			phase := phases[phaseName]
+			root.Children = append(root.Children, &planNode{Name: phase.Name, Strategy: phase.Strategy, Status: phase.Status, Steps: phase.Steps})
+		}
+	}
+	return root
+}
+
+// layoutTree returns the tree of a plan from its layout alone, for a plan which is not running.
+func layoutTree(layout *planLayout) *planNode {
+	node := &planNode{Name: layout.Name, Strategy: layout.Strategy, Status: "PENDING"}
+	for _, step := range layout.Steps {
+		node.Steps = append(node.Steps, planStep{Name: step, Status: "PENDING"})
+	}
+	for _, child := range layout.Phases {
+		node.Children = append(node.Children, layoutTree(child))
+	}
+	return node
+}
+
+// aggregateStatus derives the status of a group of phases from its members.
+func aggregateStatus(children []*planNode) string {
+	complete, pending := 0, 0
+	for _, child := range children {
+		switch child.Status {
+		case "ERROR":
+			return "ERROR"
+		case "COMPLETE":
+			complete++
+		case "PENDING", "WAITING":
+			pending++
+		}
+	}
+	switch {
+	case complete == len(children):
+		return "COMPLETE"
+	case pending == len(children):
+		return "PENDING"
+	}
+	return "IN_PROGRESS"
+}
+
+func planIsFinished(status string) bool {
+	return status == "COMPLETE" || status == "ERROR"
+}
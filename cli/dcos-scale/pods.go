@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// podTask is a task of a pod instance as reported by the scheduler's pod API.
+type podTask struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type podStatus struct {
+	Name  string    `json:"name"`
+	Tasks []podTask `json:"tasks"`
+}
+
+// running returns whether every task of the pod is RUNNING.
+func (s *podStatus) running() bool {
+	for _, task := range s.Tasks {
+		if task.Status != "RUNNING" {
+			return false
+		}
+	}
+	return len(s.Tasks) != 0
+}
+
+func (s *podStatus) taskIDs() []string {
+	ids := []string{}
+	for _, task := range s.Tasks {
+		ids = append(ids, task.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// listPods returns the names of the pod instances of a pod type, eg scheduler-0, scheduler-1.
+func listPods(podType string) ([]string, error) {
+	body, err := serviceRequest("GET", "v1/pod", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var all []string
+	if err := json.Unmarshal(body, &all); err != nil {
+		return nil, fmt.Errorf("Failed to parse pod list: %s", err)
+	}
+	pods := []string{}
+	for _, name := range all {
+		if strings.HasPrefix(name, podType+"-") {
+			pods = append(pods, name)
+		}
+	}
+	sort.Strings(pods)
+	return pods, nil
+}
+
+func fetchPodStatus(pod string) (*podStatus, error) {
+	body, err := serviceRequest("GET", "v1/pod/"+pod+"/status", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var status podStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("Failed to parse status of pod %s: %s", pod, err)
+	}
+	return &status, nil
+}
+
+// podCommand sends restart or replace to a pod instance.
+func podCommand(pod, command string) error {
+	_, err := serviceRequest("POST", "v1/pod/"+pod+"/"+command, nil, "")
+	return err
+}
+
+// podRelaunchedCondition holds once all tasks of the pod run under other task IDs than before.
+func podRelaunchedCondition(pod string, before []string) waitCondition {
+	return func() (bool, string, error) {
+		status, err := fetchPodStatus(pod)
+		if err != nil {
+			return false, "", apiFailure(err)
+		}
+		if strings.Join(status.taskIDs(), ",") == strings.Join(before, ",") {
+			return false, "waiting for " + pod + " to be relaunched", nil
+		}
+		states := []string{}
+		for _, task := range status.Tasks {
+			states = append(states, task.Name+" "+task.Status)
+		}
+		return status.running(), strings.Join(states, ", "), nil
+	}
+}
+
+// podRunningCondition holds once all tasks of the pod are RUNNING.
+func podRunningCondition(pod string) waitCondition {
+	return func() (bool, string, error) {
+		status, err := fetchPodStatus(pod)
+		if err != nil {
+			return false, "", apiFailure(err)
+		}
+		if status.running() {
+			return true, pod + " is RUNNING", nil
+		}
+		return false, "waiting for " + pod + " to be RUNNING", nil
+	}
+}
+
+// defaultPodTimeout is how long to wait for a pod to come back after a restart.
+const defaultPodTimeout = 10 * time.Minute
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal client for the Postgres frontend/backend protocol (version 3), enough to check that
+// the Scale database accepts a login and to run simple queries against it. It speaks plaintext
+// only and supports the cleartext, md5 and SCRAM-SHA-256 authentication methods.
+
+type pgConfig struct {
+	Address  string
+	User     string
+	Password string
+	Database string
+	Timeout  time.Duration
+}
+
+type pgConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// Params holds the parameters reported by the server after login, eg server_version.
+	Params map[string]string
+}
+
+// pgError is an ErrorResponse sent by the server.
+type pgError struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+func (e *pgError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Severity, e.Code, e.Message)
+}
+
+func pgConnect(config pgConfig) (*pgConn, error) {
+	conn, err := net.DialTimeout("tcp", config.Address, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	c := &pgConn{conn: conn, reader: bufio.NewReader(conn), Params: map[string]string{}}
+	if err := c.startup(config); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *pgConn) Close() error {
+	c.writeMessage('X', nil)
+	return c.conn.Close()
+}
+
+func (c *pgConn) writeMessage(kind byte, body []byte) error {
+	msg := make([]byte, 0, 5+len(body))
+	if kind != 0 {
+		msg = append(msg, kind)
+	}
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(4+len(body)))
+	msg = append(msg, length...)
+	msg = append(msg, body...)
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *pgConn) readMessage() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(binary.BigEndian.Uint32(header[1:]))
+	if length < 4 || length > 1<<24 {
+		return 0, nil, fmt.Errorf("invalid message length %d, is this a Postgres server?", length)
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+func parsePGError(body []byte) *pgError {
+	e := &pgError{}
+	for _, field := range strings.Split(string(body), "\x00") {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'S':
+			e.Severity = field[1:]
+		case 'C':
+			e.Code = field[1:]
+		case 'M':
+			e.Message = field[1:]
+		}
+	}
+	return e
+}
+
+func cstring(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func (c *pgConn) startup(config pgConfig) error {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, 196608) // protocol 3.0
+	body = append(body, cstring("user")...)
+	body = append(body, cstring(config.User)...)
+	if len(config.Database) != 0 {
+		body = append(body, cstring("database")...)
+		body = append(body, cstring(config.Database)...)
+	}
+	body = append(body, 0)
+	if err := c.writeMessage(0, body); err != nil {
+		return err
+	}
+	var scram *scramClient
+	for {
+		kind, body, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case 'E':
+			return parsePGError(body)
+		case 'S':
+			parts := strings.Split(string(body), "\x00")
+			if len(parts) >= 2 {
+				c.Params[parts[0]] = parts[1]
+			}
+		case 'Z':
+			return nil
+		case 'R':
+			if len(body) < 4 {
+				return fmt.Errorf("short authentication message")
+			}
+			method := binary.BigEndian.Uint32(body)
+			switch method {
+			case 0: // AuthenticationOk
+			case 3: // cleartext
+				err = c.writeMessage('p', cstring(config.Password))
+			case 5: // md5
+				if len(body) < 8 {
+					return fmt.Errorf("short md5 authentication message")
+				}
+				err = c.writeMessage('p', cstring(pgMD5Password(config.User, config.Password, body[4:8])))
+			case 10: // SASL
+				if !strings.Contains(string(body[4:]), "SCRAM-SHA-256\x00") {
+					return fmt.Errorf("server offers no supported SASL mechanism: %q", body[4:])
+				}
+				scram, err = newSCRAMClient(config.Password)
+				if err == nil {
+					first := scram.clientFirst()
+					msg := cstring("SCRAM-SHA-256")
+					length := make([]byte, 4)
+					binary.BigEndian.PutUint32(length, uint32(len(first)))
+					msg = append(append(msg, length...), first...)
+					err = c.writeMessage('p', msg)
+				}
+			case 11: // SASLContinue
+				if scram == nil {
+					return fmt.Errorf("unexpected SASL continuation")
+				}
+				var final string
+				final, err = scram.clientFinal(string(body[4:]))
+				if err == nil {
+					err = c.writeMessage('p', []byte(final))
+				}
+			case 12: // SASLFinal
+				if scram == nil {
+					return fmt.Errorf("unexpected SASL completion")
+				}
+				err = scram.verifyServer(string(body[4:]))
+			default:
+				return fmt.Errorf("unsupported authentication method %d", method)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// query runs a statement with the simple query protocol and returns its rows as text, with NULL
+// as the empty string.
+func (c *pgConn) query(sql string) ([][]string, error) {
+	if err := c.writeMessage('Q', cstring(sql)); err != nil {
+		return nil, err
+	}
+	rows := [][]string{}
+	var queryErr error
+	for {
+		kind, body, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case 'E':
+			// the server still sends ReadyForQuery after an error
+			queryErr = parsePGError(body)
+		case 'S':
+			parts := strings.Split(string(body), "\x00")
+			if len(parts) >= 2 {
+				c.Params[parts[0]] = parts[1]
+			}
+		case 'D':
+			row, err := parsePGDataRow(body)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		case 'Z':
+			if queryErr != nil {
+				return nil, queryErr
+			}
+			return rows, nil
+		}
+	}
+}
+
+// pgQuoteIdentifier quotes a name, eg a user, for use in a statement.
+func pgQuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// pgQuoteLiteral quotes a string for use in a statement, assuming standard_conforming_strings,
+// which is on by default since Postgres 9.1.
+func pgQuoteLiteral(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+func parsePGDataRow(body []byte) ([]string, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("short data row")
+	}
+	columns := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	row := make([]string, 0, columns)
+	for i := 0; i < columns; i++ {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("short data row")
+		}
+		length := int32(binary.BigEndian.Uint32(body))
+		body = body[4:]
+		if length < 0 {
+			row = append(row, "")
+			continue
+		}
+		if int(length) > len(body) {
+			return nil, fmt.Errorf("short data row")
+		}
+		row = append(row, string(body[:length]))
+		body = body[length:]
+	}
+	return row, nil
+}
+
+func pgMD5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// scramClient implements the client side of SCRAM-SHA-256 (RFC 5802/7677) as used by Postgres,
+// which takes the user name from the startup message and leaves it empty here.
+type scramClient struct {
+	password    string
+	nonce       string
+	firstBare   string
+	authMessage string
+	salted      []byte
+}
+
+func newSCRAMClient(password string) (*scramClient, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return &scramClient{password: password, nonce: base64.StdEncoding.EncodeToString(buf)}, nil
+}
+
+func (s *scramClient) clientFirst() string {
+	s.firstBare = "n=,r=" + s.nonce
+	return "n,," + s.firstBare
+}
+
+func scramAttributes(message string) map[string]string {
+	attributes := map[string]string{}
+	for _, part := range strings.Split(message, ",") {
+		if len(part) > 2 && part[1] == '=' {
+			attributes[part[:1]] = part[2:]
+		}
+	}
+	return attributes
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	attributes := scramAttributes(serverFirst)
+	nonce, salt64, iterations := attributes["r"], attributes["s"], attributes["i"]
+	if !strings.HasPrefix(nonce, s.nonce) {
+		return "", fmt.Errorf("SCRAM server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt: %s", err)
+	}
+	count, err := strconv.Atoi(iterations)
+	if err != nil || count < 1 {
+		return "", fmt.Errorf("invalid SCRAM iteration count %q", iterations)
+	}
+	s.salted = pbkdf2SHA256([]byte(s.password), salt, count)
+	clientKey := hmacSHA256(s.salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	finalWithoutProof := "c=biws,r=" + nonce
+	s.authMessage = s.firstBare + "," + serverFirst + "," + finalWithoutProof
+	signature := hmacSHA256(storedKey[:], []byte(s.authMessage))
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	return finalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verifyServer(serverFinal string) error {
+	attributes := scramAttributes(serverFinal)
+	if e, ok := attributes["e"]; ok {
+		return fmt.Errorf("SCRAM authentication failed: %s", e)
+	}
+	serverKey := hmacSHA256(s.salted, []byte("Server Key"))
+	expected := base64.StdEncoding.EncodeToString(hmacSHA256(serverKey, []byte(s.authMessage)))
+	if attributes["v"] != expected {
+		return fmt.Errorf("SCRAM server signature does not match")
+	}
+	return nil
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// pbkdf2SHA256 derives a single 32 byte block, which is all SCRAM-SHA-256 needs.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	u := hmacSHA256(password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSHA256(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
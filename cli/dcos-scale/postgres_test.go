@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pgStandIn is a Postgres server that knows one user and answers the queries in rows.
+type pgStandIn struct {
+	listener net.Listener
+	// method is the authentication the server asks for: md5 or scram.
+	method   string
+	user     string
+	password string
+	rows     map[string][][]string
+}
+
+func startPGStandIn(t *testing.T, method, user, password string) *pgStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &pgStandIn{listener: listener, method: method, user: user, password: password, rows: map[string][][]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *pgStandIn) config(password string) pgConfig {
+	return pgConfig{Address: s.listener.Addr().String(), User: s.user, Password: password, Database: "scale", Timeout: 5 * time.Second}
+}
+
+func (s *pgStandIn) Close() {
+	s.listener.Close()
+}
+
+func pgWrite(w io.Writer, kind byte, body []byte) {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(4+len(body)))
+	w.Write(append(append([]byte{kind}, length...), body...))
+}
+
+func pgAuthentication(method uint32, data []byte) []byte {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, method)
+	return append(body, data...)
+}
+
+func pgRead(r *bufio.Reader) (byte, []byte, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header)-4)
+	_, err = io.ReadFull(r, body)
+	return kind, body, err
+}
+
+func (s *pgStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	startup := make([]byte, binary.BigEndian.Uint32(header)-4)
+	if _, err := io.ReadFull(reader, startup); err != nil {
+		return
+	}
+	params := strings.Split(string(startup[4:]), "\x00")
+	user := ""
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i] == "user" {
+			user = params[i+1]
+		}
+	}
+	var ok bool
+	if s.method == "md5" {
+		ok = s.md5(conn, reader, user)
+	} else {
+		ok = s.scram(conn, reader, user)
+	}
+	if !ok {
+		pgWrite(conn, 'E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed for user \""+user+"\"\x00\x00"))
+		return
+	}
+	pgWrite(conn, 'R', pgAuthentication(0, nil))
+	pgWrite(conn, 'S', []byte("server_version\x0010.5\x00"))
+	pgWrite(conn, 'Z', []byte("I"))
+	for {
+		kind, body, err := pgRead(reader)
+		if err != nil || kind == 'X' {
+			return
+		}
+		if kind != 'Q' {
+			continue
+		}
+		sql := strings.TrimRight(string(body), "\x00")
+		rows, known := s.rows[sql]
+		if !known {
+			pgWrite(conn, 'E', []byte("SERROR\x0042601\x00Msyntax error\x00\x00"))
+		}
+		for _, row := range rows {
+			var data bytes.Buffer
+			binary.Write(&data, binary.BigEndian, uint16(len(row)))
+			for _, value := range row {
+				binary.Write(&data, binary.BigEndian, uint32(len(value)))
+				data.WriteString(value)
+			}
+			pgWrite(conn, 'D', data.Bytes())
+		}
+		pgWrite(conn, 'Z', []byte("I"))
+	}
+}
+
+func (s *pgStandIn) md5(conn net.Conn, reader *bufio.Reader, user string) bool {
+	salt := []byte{1, 2, 3, 4}
+	pgWrite(conn, 'R', pgAuthentication(5, salt))
+	kind, body, err := pgRead(reader)
+	return err == nil && kind == 'p' && user == s.user &&
+		strings.TrimRight(string(body), "\x00") == pgMD5Password(s.user, s.password, salt)
+}
+
+// scram checks the client proof of a SCRAM-SHA-256 exchange against the stored key derived from
+// the password, as a server holding only the verifier would.
+func (s *pgStandIn) scram(conn net.Conn, reader *bufio.Reader, user string) bool {
+	pgWrite(conn, 'R', pgAuthentication(10, []byte("SCRAM-SHA-256\x00\x00")))
+	kind, body, err := pgRead(reader)
+	if err != nil || kind != 'p' {
+		return false
+	}
+	mechanism := string(body[:bytes.IndexByte(body, 0)])
+	clientFirst := string(body[len(mechanism)+5:])
+	if mechanism != "SCRAM-SHA-256" || !strings.HasPrefix(clientFirst, "n,,") {
+		return false
+	}
+	clientFirstBare := strings.TrimPrefix(clientFirst, "n,,")
+	salt := []byte("stand-in salt")
+	nonce := scramAttributes(clientFirstBare)["r"] + "server-nonce"
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=4096", nonce, base64.StdEncoding.EncodeToString(salt))
+	pgWrite(conn, 'R', pgAuthentication(11, []byte(serverFirst)))
+	kind, body, err = pgRead(reader)
+	if err != nil || kind != 'p' {
+		return false
+	}
+	clientFinal := string(body)
+	proofAt := strings.LastIndex(clientFinal, ",p=")
+	if proofAt < 0 || scramAttributes(clientFinal)["r"] != nonce {
+		return false
+	}
+	proof, err := base64.StdEncoding.DecodeString(clientFinal[proofAt+3:])
+	if err != nil || len(proof) != sha256.Size {
+		return false
+	}
+	salted := pbkdf2SHA256([]byte(s.password), salt, 4096)
+	storedKey := sha256.Sum256(hmacSHA256(salted, []byte("Client Key")))
+	authMessage := clientFirstBare + "," + serverFirst + "," + clientFinal[:proofAt]
+	signature := hmacSHA256(storedKey[:], []byte(authMessage))
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ signature[i]
+	}
+	derived := sha256.Sum256(clientKey)
+	if user != s.user || subtle.ConstantTimeCompare(derived[:], storedKey[:]) != 1 {
+		return false
+	}
+	serverSignature := hmacSHA256(hmacSHA256(salted, []byte("Server Key")), []byte(authMessage))
+	pgWrite(conn, 'R', pgAuthentication(12, []byte("v="+base64.StdEncoding.EncodeToString(serverSignature))))
+	return true
+}
+
+func TestPGConnectMD5(t *testing.T) {
+	server := startPGStandIn(t, "md5", "scale", "hunter2")
+	defer server.Close()
+	conn, err := pgConnect(server.config("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Params["server_version"] != "10.5" {
+		t.Errorf("server_version is %q", conn.Params["server_version"])
+	}
+}
+
+func TestPGConnectSCRAM(t *testing.T) {
+	server := startPGStandIn(t, "scram", "scale", "hunter2")
+	defer server.Close()
+	conn, err := pgConnect(server.config("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestPGConnectWrongPassword(t *testing.T) {
+	for _, method := range []string{"md5", "scram"} {
+		server := startPGStandIn(t, method, "scale", "hunter2")
+		_, err := pgConnect(server.config("wrong"))
+		server.Close()
+		if pgErr, ok := err.(*pgError); !ok || pgErr.Code != "28P01" {
+			t.Errorf("%s login with a wrong password returned %v, expected 28P01", method, err)
+		}
+	}
+}
+
+func TestPGQuery(t *testing.T) {
+	server := startPGStandIn(t, "md5", "scale", "hunter2")
+	defer server.Close()
+	server.rows["SELECT extversion FROM pg_extension WHERE extname = 'postgis'"] = [][]string{{"2.4.4"}}
+	server.rows["SHOW missing"] = nil
+	conn, err := pgConnect(server.config("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if check := checkPostGIS(conn, "scale"); !check.OK || check.Detail != "PostGIS 2.4.4" {
+		t.Errorf("checkPostGIS returned %+v", check)
+	}
+	if value, err := queryValue(conn, "SHOW missing"); err != nil || value != "" {
+		t.Errorf("a query without rows returned %q, %v", value, err)
+	}
+	if _, err := conn.query("SELEKT 1"); err == nil {
+		t.Errorf("a failing query returned no error")
+	}
+	// the connection is still usable after an error
+	if _, err := queryValue(conn, "SHOW missing"); err != nil {
+		t.Errorf("query after an error returned %v", err)
+	}
+}
+
+// TestSCRAMClientVectors checks the client against the exchange in RFC 7677 section 3.
+func TestSCRAMClientVectors(t *testing.T) {
+	client := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO", firstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO"}
+	final, err := client.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != expected {
+		t.Errorf("client final is %s, expected %s", final, expected)
+	}
+	if err := client.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Error(err)
+	}
+	if err := client.verifyServer("v=AAAA"); err == nil {
+		t.Error("a wrong server signature was accepted")
+	}
+}
+
+func TestProbeDBReadsPasswordFromSecret(t *testing.T) {
+	server := startPGStandIn(t, "scram", "scale", "from-store")
+	defer server.Close()
+	_, restore := withSecretStore(map[string]string{"scale/db-pass": "from-store"})
+	defer restore()
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	options := map[string]interface{}{
+		"db.db-host":        host,
+		"db.db-port":        port,
+		"db.db-pass":        "plaintext",
+		"db.db-pass-secret": "scale/db-pass",
+	}
+	if result := probeDB(options, 5*time.Second); !result.OK || !result.External {
+		t.Errorf("probe returned %+v", result)
+	}
+}
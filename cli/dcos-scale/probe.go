@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Fixed ports of the supporting pods in svc.yml.
+const (
+	dbPort                 = 5432
+	brokerPort             = 5672
+	brokerManagementPort   = 15672
+	logstashLoggingPort    = 9229
+	logstashHealthCheckVIP = 80
+)
+
+var probeChecks = []string{"db", "broker", "management", "logstash"}
+
+// taskAddress returns the address of a pod's task inside the cluster, eg db-0-launch.
+func taskAddress(pod, task string, port int) string {
+	return fmt.Sprintf("%s-0-%s.%s.autoip.dcos.thisdcos.directory:%d", pod, task, strings.Trim(config.ServiceName, "/"), port)
+}
+
+// vipAddress returns the load balanced address of a port advertised with a VIP.
+func vipAddress(prefix string, port int) string {
+	return fmt.Sprintf("%s.%s.l4lb.thisdcos.directory:%d", prefix, strings.Trim(config.ServiceName, "/"), port)
+}
+
+type probeResult struct {
+	Check    string        `json:"check" yaml:"check"`
+	Address  string        `json:"address" yaml:"address"`
+	External bool          `json:"external" yaml:"external"`
+	OK       bool          `json:"ok" yaml:"ok"`
+	Detail   string        `json:"detail" yaml:"detail"`
+	Latency  time.Duration `json:"latencyNanos" yaml:"latencyNanos"`
+}
+
+// brokerTarget is the AMQP endpoint and credentials of the broker.
+type brokerTarget struct {
+	Address     string
+	User        string
+	Password    string
+	VirtualHost string
+	External    bool
+	TLS         bool
+}
+
+// resolveBrokerTarget returns the broker from messaging.broker-url or the secret it is kept in, the
+// first one when it lists several, or the rabbitmq pod when it is left empty. Brokers other than
+// AMQP are returned as nil.
+func resolveBrokerTarget(store secretStore, options map[string]interface{}) (*brokerTarget, error) {
+	brokerURL, err := secretOptionValue(store, options, "broker-url")
+	if err != nil {
+		return nil, err
+	}
+	if len(brokerURL) == 0 {
+		return &brokerTarget{Address: taskAddress("rabbitmq", "launch", brokerPort), User: "guest", Password: "guest", VirtualHost: "/"}, nil
+	}
+	urls, err := parseKombuURLs(brokerURL)
+	if err != nil {
+		return nil, err
+	}
+	parsed := urls[0]
+	if parsed.Transport != "amqp" {
+		return nil, nil
+	}
+	return &brokerTarget{
+		Address:     parsed.Address(),
+		User:        parsed.User,
+		Password:    parsed.Password,
+		VirtualHost: parsed.VirtualHost,
+		External:    true,
+		TLS:         parsed.TLS(),
+	}, nil
+}
+
+// brokerTLSConfig returns the TLS settings for connecting to a broker.
+func brokerTLSConfig(address string) *tls.Config {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return &tls.Config{ServerName: host, InsecureSkipVerify: config.TlsForceInsecure}
+}
+
+func (t *brokerTarget) managementURL() string {
+	host, _, err := net.SplitHostPort(t.Address)
+	if err != nil {
+		host = t.Address
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, fmt.Sprint(brokerManagementPort)))
+}
+
+func timed(result *probeResult, check func() (string, error)) probeResult {
+	started := time.Now()
+	detail, err := check()
+	result.Latency = time.Since(started)
+	if err != nil {
+		result.Detail = err.Error()
+	} else {
+		result.OK = true
+		result.Detail = detail
+	}
+	return *result
+}
+
+// brokerOption describes the broker option for results that have no broker address, without the
+// credentials a URL may hold.
+func brokerOption(options map[string]interface{}) string {
+	if path := optionString(options, "messaging.broker-url-secret", ""); len(path) != 0 {
+		return "secret " + path
+	}
+	return "messaging.broker-url"
+}
+
+// dbAddress returns the address of the database Scale uses and whether it is external.
+func dbAddress(options map[string]interface{}) (string, bool) {
+	if host := optionString(options, "db.db-host", ""); len(host) != 0 {
+		return net.JoinHostPort(host, optionString(options, "db.db-port", fmt.Sprint(dbPort))), true
+	}
+	return taskAddress("db", "launch", dbPort), false
+}
+
+func probeDB(options map[string]interface{}, timeout time.Duration) probeResult {
+	result := &probeResult{Check: "db"}
+	result.Address, result.External = dbAddress(options)
+	return timed(result, func() (string, error) {
+		password, err := secretOptionValue(newSecretStore(), options, "db-pass")
+		if err != nil {
+			return "", err
+		}
+		if len(password) == 0 {
+			password = "scale"
+		}
+		conn, err := pgConnect(pgConfig{
+			Address:  result.Address,
+			User:     optionString(options, "db.db-user", "scale"),
+			Password: password,
+			Database: optionString(options, "db.db-name", "scale"),
+			Timeout:  timeout,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return "authenticated, server version " + conn.Params["server_version"], nil
+	})
+}
+
+func probeBroker(options map[string]interface{}, timeout time.Duration) probeResult {
+	result := &probeResult{Check: "broker"}
+	target, err := resolveBrokerTarget(newSecretStore(), options)
+	if err != nil || target == nil {
+		result.Address = brokerOption(options)
+		result.External = true
+		if err == nil {
+			err = fmt.Errorf("not an AMQP broker, skipped")
+		}
+		result.Detail = err.Error()
+		return *result
+	}
+	result.Address, result.External = target.Address, target.External
+	return timed(result, func() (string, error) {
+		amqp := amqpConfig{
+			Address:     target.Address,
+			User:        target.User,
+			Password:    target.Password,
+			VirtualHost: target.VirtualHost,
+			Timeout:     timeout,
+		}
+		if target.TLS {
+			amqp.TLS = brokerTLSConfig(target.Address)
+		}
+		info, err := amqpHandshake(amqp)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("connection opened on vhost %s, %s %s", target.VirtualHost, info.Product, info.Version), nil
+	})
+}
+
+func probeManagement(options map[string]interface{}, timeout time.Duration) probeResult {
+	result := &probeResult{Check: "management"}
+	target, err := resolveBrokerTarget(newSecretStore(), options)
+	if err != nil || target == nil {
+		result.Address = brokerOption(options)
+		result.External = true
+		if err == nil {
+			err = fmt.Errorf("not a RabbitMQ broker, skipped")
+		}
+		result.Detail = err.Error()
+		return *result
+	}
+	management := &rabbitmqManagement{BaseURL: target.managementURL(), User: target.User, Password: target.Password, Timeout: timeout}
+	result.Address, result.External = management.BaseURL, target.External
+	return timed(result, func() (string, error) {
+		overview, err := management.overview()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("RabbitMQ %s, management %s", overview.RabbitMQVersion, overview.ManagementVersion), nil
+	})
+}
+
+func probeLogstash(options map[string]interface{}, timeout time.Duration) probeResult {
+	result := &probeResult{Check: "logstash", Address: "http://" + vipAddress("logstash", logstashHealthCheckVIP)}
+	address := optionString(options, "logging.logstash-address", "")
+	if len(address) == 0 {
+		return timed(result, func() (string, error) {
+			response, err := (&http.Client{Timeout: timeout}).Get(result.Address)
+			if err != nil {
+				return "", err
+			}
+			response.Body.Close()
+			if response.StatusCode != 200 {
+				return "", fmt.Errorf("health check returned %d", response.StatusCode)
+			}
+			return "health check answered 200", nil
+		})
+	}
+	// an external logstash is only known by the address Scale ships logs to
+	result.Address, result.External = address, true
+	if parsed, err := url.Parse(address); err == nil && len(parsed.Host) != 0 {
+		result.Address = parsed.Host
+	}
+	return timed(result, func() (string, error) {
+		conn, err := net.DialTimeout("tcp", result.Address, timeout)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "accepts connections", nil
+	})
+}
+
+type probeHandler struct {
+	checks      []string
+	optionsFile string
+	timeout     time.Duration
+}
+
+func (cmd *probeHandler) handleProbe(c *kingpin.ParseContext) error {
+	options, err := serviceOptions(cmd.optionsFile)
+	if err != nil {
+		return err
+	}
+	checks := cmd.checks
+	if len(checks) == 0 {
+		checks = probeChecks
+	}
+	probes := map[string]func(map[string]interface{}, time.Duration) probeResult{
+		"db":         probeDB,
+		"broker":     probeBroker,
+		"management": probeManagement,
+		"logstash":   probeLogstash,
+	}
+	results := []probeResult{}
+	failed := 0
+	for _, check := range checks {
+		result := probes[check](options, cmd.timeout)
+		if !result.OK {
+			failed++
+		}
+		results = append(results, result)
+	}
+	if printed, err := printStructured(results); !printed {
+		rows := [][]string{}
+		for _, result := range results {
+			status := "OK"
+			if !result.OK {
+				status = "FAIL"
+			}
+			where := "pod"
+			if result.External {
+				where = "external"
+			}
+			rows = append(rows, []string{result.Check, where, result.Address, status, roundLatency(result.Latency), result.Detail})
+		}
+		printTable([]string{"CHECK", "TARGET", "ADDRESS", "STATUS", "LATENCY", "DETAIL"}, rows)
+	} else if err != nil {
+		return err
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d probes failed", failed, len(results))
+	}
+	return nil
+}
+
+func roundLatency(d time.Duration) string {
+	return (d - d%time.Millisecond).String()
+}
+
+func handleProbeSection(app *kingpin.Application) {
+	cmd := &probeHandler{}
+	probe := app.Command("probe", "Check the supporting services at the protocol level").Action(cmd.handleProbe)
+	probe.Arg("checks", fmt.Sprintf("Checks to run (%s), defaults to all", strings.Join(probeChecks, ", "))).EnumsVar(&cmd.checks, probeChecks...)
+	probe.Flag("options", "Options file to take external addresses and credentials from instead of the installed service").StringVar(&cmd.optionsFile)
+	probe.Flag("timeout", "Timeout for each check").Default("5s").DurationVar(&cmd.timeout)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Profiles let one CLI install manage several Scale instances, eg dev/staging/prod, which may
+// live on different clusters and under different service names. They are kept in
+// ~/.dcos-scale.toml:
+//
+//   current = "dev"
+//
+//   [profiles.dev]
+//   cluster-url = "https://dev.example.com"
+//   service-name = "scale-dev"
+//   auth-token = "env:DEV_DCOS_TOKEN"
+//   api-base-path = "/service/scale-dev/api"
+//   api-version = "v6"
+//   output = "json"
+
+const profilesFileName = ".dcos-scale.toml"
+
+var outputFormats = []string{"text", "json", "yaml"}
+
+type profile struct {
+	ClusterURL string `toml:"cluster-url"`
+	// ServiceName is the service.name of the instance, which also prefixes its supporting services.
+	ServiceName string `toml:"service-name"`
+	// AuthToken is where to read the auth token from: "dcos" for the DC/OS CLI's own token,
+	// "env:<VAR>", "file:<path>" or "cmd:<command>".
+	AuthToken   string `toml:"auth-token"`
+	APIBasePath string `toml:"api-base-path"`
+	// APIVersion pins the Scale API version instead of asking the instance, eg v5.
+	APIVersion string `toml:"api-version"`
+	Output     string `toml:"output"`
+}
+
+type profilesFile struct {
+	Current  string             `toml:"current"`
+	Profiles map[string]profile `toml:"profiles"`
+}
+
+var (
+	// profileName is set by the global --profile flag.
+	profileName string
+	// activeProfile is the profile in effect for this invocation, if any.
+	activeProfile *profile
+	// outputFormat is the format commands print structured results in.
+	outputFormat string
+	// pendingTokenProfile is the profile whose auth token is read on the first cluster request,
+	// so that commands which never reach a cluster neither run nor fail on its token source.
+	pendingTokenProfile *profile
+	pendingTokenName    string
+	// sdkCommands are the top level commands of the default sections, which call the cluster
+	// through the SDK and so need the token up front.
+	sdkCommands = map[string]bool{}
+)
+
+func profilesPath() string {
+	if path := os.Getenv("DCOS_SCALE_PROFILES"); len(path) != 0 {
+		return path
+	}
+	return homePath(profilesFileName)
+}
+
+// homePath returns the path of a file in the user's home directory.
+func homePath(name string) string {
+	home := os.Getenv("HOME")
+	if len(home) == 0 {
+		home = os.Getenv("USERPROFILE")
+	}
+	return filepath.Join(home, name)
+}
+
+func loadProfiles() (*profilesFile, error) {
+	profiles := &profilesFile{Profiles: map[string]profile{}}
+	data, err := ioutil.ReadFile(profilesPath())
+	if os.IsNotExist(err) {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := toml.Unmarshal(data, profiles); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", profilesPath(), err)
+	}
+	if profiles.Profiles == nil {
+		profiles.Profiles = map[string]profile{}
+	}
+	return profiles, nil
+}
+
+func (f *profilesFile) get(name string) (*profile, error) {
+	p, ok := f.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("No profile named '%s' in %s", name, profilesPath())
+	}
+	return &p, nil
+}
+
+func (f *profilesFile) names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveAuthToken reads the token from the profile's configured source. An empty result means
+// the DC/OS CLI's own token is used.
+func (p *profile) resolveAuthToken() (string, error) {
+	source := p.AuthToken
+	switch {
+	case len(source) == 0 || source == "dcos":
+		return "", nil
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		token := os.Getenv(name)
+		if len(token) == 0 {
+			return "", fmt.Errorf("Environment variable %s is empty", name)
+		}
+		return token, nil
+	case strings.HasPrefix(source, "file:"):
+		path := strings.TrimPrefix(source, "file:")
+		if strings.HasPrefix(path, "~/") {
+			path = filepath.Join(os.Getenv("HOME"), path[2:])
+		}
+		token, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(token)), nil
+	case strings.HasPrefix(source, "cmd:"):
+		token, err := exec.Command("sh", "-c", strings.TrimPrefix(source, "cmd:")).Output()
+		if err != nil {
+			return "", fmt.Errorf("Token command failed: %s", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+	return "", fmt.Errorf("Unknown auth-token source '%s', expected dcos, env:, file: or cmd:", source)
+}
+
+// flagWasSet returns whether a global flag was given explicitly on the command line, in which
+// case it takes precedence over the profile.
+func flagWasSet(c *kingpin.ParseContext, name string) bool {
+	for _, element := range c.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok && flag.Model().Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the value a flag was given on the command line, or the empty string.
+func flagValue(c *kingpin.ParseContext, name string) string {
+	for _, element := range c.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok && flag.Model().Name == name && element.Value != nil {
+			return *element.Value
+		}
+	}
+	return ""
+}
+
+// applyProfile runs before every command and fills in the connection settings from the selected
+// profile: the one named with --profile, or else the file's current profile. The profiles
+// commands are left alone, so a broken profile can still be listed, shown and switched away from.
+func applyProfile(c *kingpin.ParseContext) error {
+	if len(outputFormat) == 0 {
+		outputFormat = "text"
+	}
+	command := ""
+	if c.SelectedCommand != nil {
+		command = strings.Fields(c.SelectedCommand.FullCommand())[0]
+	}
+	if command == "profiles" {
+		return nil
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	name := profileName
+	if len(name) == 0 {
+		name = profiles.Current
+	}
+	if len(name) != 0 {
+		p, err := profiles.get(name)
+		if err != nil {
+			return err
+		}
+		if len(p.ClusterURL) != 0 && !flagWasSet(c, "custom-dcos-url") {
+			config.DcosUrl = p.ClusterURL
+		}
+		if len(p.ServiceName) != 0 && !flagWasSet(c, "name") {
+			config.ServiceName = p.ServiceName
+		}
+		if len(p.Output) != 0 && !flagWasSet(c, "output") {
+			outputFormat = p.Output
+		}
+		activeProfile = p
+		if !flagWasSet(c, "custom-auth-token") {
+			pendingTokenProfile, pendingTokenName = p, name
+			if sdkCommands[command] {
+				return resolvePendingToken()
+			}
+		}
+	}
+	return nil
+}
+
+// resolvePendingToken reads the auth token of the profile in effect, if that has not been done.
+func resolvePendingToken() error {
+	if pendingTokenProfile == nil {
+		return nil
+	}
+	token, err := pendingTokenProfile.resolveAuthToken()
+	if err != nil {
+		return fmt.Errorf("Failed to read auth token for profile '%s': %s", pendingTokenName, err)
+	}
+	if len(token) != 0 {
+		config.DcosAuthToken = token
+	}
+	pendingTokenProfile = nil
+	return nil
+}
+
+// useProfile switches the connection settings to a profile in the middle of a command, for
+// commands which talk to more than one instance. Unlike applyProfile it ignores the global flags,
+// and the cluster and token fall back to the DC/OS CLI's when the profile leaves them out.
+func (f *profilesFile) useProfile(name string) error {
+	p, err := f.get(name)
+	if err != nil {
+		return err
+	}
+	token, err := p.resolveAuthToken()
+	if err != nil {
+		return fmt.Errorf("Failed to read auth token for profile '%s': %s", name, err)
+	}
+	config.DcosUrl = p.ClusterURL
+	if len(p.ServiceName) != 0 {
+		config.ServiceName = p.ServiceName
+	}
+	config.DcosAuthToken = token
+	pendingTokenProfile = nil
+	activeProfile = p
+	negotiatedScaleAPI = nil
+	return nil
+}
+
+// scaleAPIBasePath is where the Scale REST API is reached through the admin router.
+func scaleAPIBasePath() string {
+	if activeProfile != nil && len(activeProfile.APIBasePath) != 0 {
+		return "/" + strings.Trim(activeProfile.APIBasePath, "/")
+	}
+	return fmt.Sprintf("/service/%s/api", strings.Trim(config.ServiceName, "/"))
+}
+
+type profilesHandler struct {
+	name string
+}
+
+func (cmd *profilesHandler) handleList(c *kingpin.ParseContext) error {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	if len(profiles.Profiles) == 0 {
+		client.PrintMessage("No profiles defined in %s.", profilesPath())
+		return nil
+	}
+	for _, name := range profiles.names() {
+		p := profiles.Profiles[name]
+		marker := " "
+		if name == profiles.Current {
+			marker = "*"
+		}
+		client.PrintMessage("%s %s\t%s\t%s", marker, name, p.ClusterURL, p.ServiceName)
+	}
+	return nil
+}
+
+// handleUse rewrites the current profile in place, keeping the rest of the file and its comments.
+func (cmd *profilesHandler) handleUse(c *kingpin.ParseContext) error {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	if _, err := profiles.get(cmd.name); err != nil {
+		return err
+	}
+	tree, err := loadOptionsFile(profilesPath(), "toml")
+	if err != nil {
+		return err
+	}
+	if current := tree.get("current"); current != nil {
+		current.Value = cmd.name
+	} else {
+		tree.Entries = append([]*optionsEntry{{Key: "current", Value: cmd.name}}, tree.Entries...)
+	}
+	out, err := formatOptions(tree, "toml")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(profilesPath(), out, 0600); err != nil {
+		return err
+	}
+	client.PrintMessage("Now using profile '%s'.", cmd.name)
+	return nil
+}
+
+func (cmd *profilesHandler) handleShow(c *kingpin.ParseContext) error {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	name := cmd.name
+	if len(name) == 0 {
+		name = profileName
+	}
+	if len(name) == 0 {
+		name = profiles.Current
+	}
+	if len(name) == 0 {
+		return fmt.Errorf("No profile given and no current profile set in %s", profilesPath())
+	}
+	p, err := profiles.get(name)
+	if err != nil {
+		return err
+	}
+	authToken := p.AuthToken
+	if len(authToken) == 0 {
+		authToken = "dcos"
+	}
+	apiBasePath := p.APIBasePath
+	if len(apiBasePath) == 0 {
+		apiBasePath = fmt.Sprintf("/service/%s/api (default)", p.ServiceName)
+	}
+	apiVersion := p.APIVersion
+	if len(apiVersion) == 0 {
+		apiVersion = "detected"
+	}
+	client.PrintMessage("Profile:       %s", name)
+	client.PrintMessage("Cluster URL:   %s", p.ClusterURL)
+	client.PrintMessage("Service name:  %s", p.ServiceName)
+	client.PrintMessage("Auth token:    %s", authToken)
+	client.PrintMessage("API base path: %s", apiBasePath)
+	client.PrintMessage("API version:   %s", apiVersion)
+	client.PrintMessage("Output:        %s", p.Output)
+	return nil
+}
+
+func handleProfilesSection(app *kingpin.Application) {
+	app.Flag("profile", fmt.Sprintf("Profile from ~/%s to use for this command", profilesFileName)).
+		OverrideDefaultFromEnvar("DCOS_SCALE_PROFILE").StringVar(&profileName)
+	app.Flag("output", "Format for command results").EnumVar(&outputFormat, outputFormats...)
+	for _, command := range app.Model().Commands {
+		sdkCommands[command.Name] = true
+	}
+	app.PreAction(applyProfile)
+
+	cmd := &profilesHandler{}
+	profiles := app.Command("profiles", fmt.Sprintf("Manage cluster/service profiles in ~/%s", profilesFileName))
+
+	profiles.Command("list", "List the configured profiles").Action(cmd.handleList)
+
+	use := profiles.Command("use", "Make a profile the current one").Action(cmd.handleUse)
+	use.Arg("name", "Name of the profile").Required().StringVar(&cmd.name)
+
+	show := profiles.Command("show", "Show the settings of a profile").Action(cmd.handleShow)
+	show.Arg("name", "Name of the profile, defaults to the one in use").StringVar(&cmd.name)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// promote copies job and recipe type definitions from one Scale instance to another, each reached
+// through its profile. Workspaces often have different names on each instance, eg staging-products
+// and products, so the workspaces a definition writes to can be renamed through a mapping file:
+//
+//   staging-products: products
+//   staging-scratch: scratch
+
+// promoteInterfaceFields are the fields which a job or recipe type's users depend on. A target
+// revision which differs in them is incompatible, as running recipes and feeds would break.
+var promoteInterfaceFields = map[string][]string{
+	"job-types":    {"interface", "manifest"},
+	"recipe-types": {"definition"},
+}
+
+// remapWorkspaces renames the workspaces a definition refers to, that is every string held under
+// a field whose name mentions workspaces, and returns the names it ends up referring to.
+func remapWorkspaces(value interface{}, mapping map[string]string) (interface{}, []string) {
+	referenced := map[string]bool{}
+	var walk func(value interface{}, inWorkspace bool) interface{}
+	walk = func(value interface{}, inWorkspace bool) interface{} {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			remapped := map[string]interface{}{}
+			for key, child := range v {
+				remapped[key] = walk(child, inWorkspace || strings.Contains(key, "workspace"))
+			}
+			return remapped
+		case []interface{}:
+			remapped := make([]interface{}, len(v))
+			for i, child := range v {
+				remapped[i] = walk(child, inWorkspace)
+			}
+			return remapped
+		case string:
+			if !inWorkspace {
+				return v
+			}
+			if target, ok := mapping[v]; ok {
+				v = target
+			}
+			referenced[v] = true
+			return v
+		}
+		return value
+	}
+	remapped := walk(value, false)
+	names := make([]string, 0, len(referenced))
+	for name := range referenced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return remapped, names
+}
+
+// recipeJobTypes returns the name:version of the job types a recipe type's definition runs,
+// whether they are given as job_type objects or as job_type_name/job_type_version pairs.
+func recipeJobTypes(definition interface{}) []string {
+	found := map[string]bool{}
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if jobType, ok := v["job_type"].(map[string]interface{}); ok && jobType["name"] != nil {
+				found[fmt.Sprintf("%v:%v", jobType["name"], jobType["version"])] = true
+			}
+			if name, ok := v["job_type_name"]; ok {
+				found[fmt.Sprintf("%v:%v", name, v["job_type_version"])] = true
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(definition)
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// promotion is one definition to copy and what copying it does to the target.
+type promotion struct {
+	action  *applyAction
+	changes []optionChange
+}
+
+// promoteChanges lists the differences between the target's definition, if any, and the promoted
+// one by dotted path.
+func promoteChanges(target, promoted map[string]interface{}) []optionChange {
+	before := flattenOptions(target)
+	after := flattenOptions(promoted)
+	changes := []optionChange{}
+	for path, value := range after {
+		if !optionValuesEqual(before[path], value) {
+			changes = append(changes, optionChange{Path: path, From: before[path], To: value})
+		}
+	}
+	for path, value := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, optionChange{Path: path, From: value})
+		}
+	}
+	sortOptionChanges(changes)
+	return changes
+}
+
+func printPromotion(p *promotion) {
+	switch p.action.Action {
+	case applyNoop:
+		client.PrintMessage("%s %s: already identical on the target", p.action.Kind, p.action.Key)
+		return
+	case applyCreate:
+		client.PrintMessage("%s %s: create", p.action.Kind, p.action.Key)
+	default:
+		client.PrintMessage("%s %s: update %s", p.action.Kind, p.action.Key, strings.Join(p.action.Fields, ", "))
+	}
+	for _, change := range p.changes {
+		from, _ := json.Marshal(change.From)
+		to, _ := json.Marshal(change.To)
+		switch {
+		case change.From == nil:
+			client.PrintMessage("  + %s: %s", change.Path, to)
+		case change.To == nil:
+			client.PrintMessage("  - %s: %s", change.Path, from)
+		default:
+			client.PrintMessage("  ~ %s: %s -> %s", change.Path, from, to)
+		}
+	}
+}
+
+type promoteHandler struct {
+	fromProfile  string
+	toProfile    string
+	jobTypes     []string
+	recipeTypes  []string
+	workspaceMap string
+	dryRun       bool
+	yes          bool
+}
+
+// readWorkspaceMap reads the source to target workspace names from a JSON, YAML or TOML file.
+func readWorkspaceMap(path string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if len(path) == 0 {
+		return mapping, nil
+	}
+	options, err := readOptionsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for from, to := range options {
+		name, ok := to.(string)
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("Workspace %s is mapped to %v in %s, expected a workspace name", from, to, path)
+		}
+		mapping[from] = name
+	}
+	return mapping, nil
+}
+
+// sourceDefinitions reads the requested definitions of a kind from the source instance.
+func sourceDefinitions(adapter *scaleAPIAdapter, kind *scaleObjectKind, keys []string) ([]map[string]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	objects, err := fetchScaleObjects(kind)
+	if err != nil {
+		return nil, err
+	}
+	definitions := []map[string]interface{}{}
+	for _, key := range keys {
+		if fields := kind.keyFields(adapter); len(strings.Split(key, ":")) != len(fields) {
+			return nil, fmt.Errorf("Invalid %s '%s': expected %s", kind.Name, key, strings.Join(fields, ":"))
+		}
+		object, ok := objects[key]
+		if !ok {
+			return nil, fmt.Errorf("The source instance has no %s %s", kind.Name, key)
+		}
+		definitions = append(definitions, kind.stripServerFields(adapter, object.Object))
+	}
+	return definitions, nil
+}
+
+func (cmd *promoteHandler) handlePromote(c *kingpin.ParseContext) error {
+	if len(cmd.jobTypes) == 0 && len(cmd.recipeTypes) == 0 {
+		return fmt.Errorf("Nothing to promote: give --job-type or --recipe-type")
+	}
+	if cmd.fromProfile == cmd.toProfile {
+		return fmt.Errorf("The source and target profiles are both '%s'", cmd.fromProfile)
+	}
+	mapping, err := readWorkspaceMap(cmd.workspaceMap)
+	if err != nil {
+		return err
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	jobTypeKind, _ := scaleObjectKindNamed("job-types")
+	recipeTypeKind, _ := scaleObjectKindNamed("recipe-types")
+	workspaceKind, _ := scaleObjectKindNamed("workspaces")
+
+	if err := profiles.useProfile(cmd.fromProfile); err != nil {
+		return err
+	}
+	sourceAPI, err := scaleAPI()
+	if err != nil {
+		return err
+	}
+	sources := map[*scaleObjectKind][]map[string]interface{}{}
+	if sources[jobTypeKind], err = sourceDefinitions(sourceAPI, jobTypeKind, cmd.jobTypes); err != nil {
+		return err
+	}
+	if sources[recipeTypeKind], err = sourceDefinitions(sourceAPI, recipeTypeKind, cmd.recipeTypes); err != nil {
+		return err
+	}
+
+	if err := profiles.useProfile(cmd.toProfile); err != nil {
+		return err
+	}
+	// definitions differ in shape between API versions, so they are only copied between equals
+	targetAPI, err := scaleAPI()
+	if err != nil {
+		return err
+	}
+	if targetAPI.Version != sourceAPI.Version {
+		return fmt.Errorf("Profile '%s' serves Scale API %s and profile '%s' serves %s: definitions can only be promoted between instances on the same API version",
+			cmd.fromProfile, sourceAPI.Version, cmd.toProfile, targetAPI.Version)
+	}
+	workspaces, err := fetchScaleObjects(workspaceKind)
+	if err != nil {
+		return err
+	}
+	targetJobTypes, err := fetchScaleObjects(jobTypeKind)
+	if err != nil {
+		return err
+	}
+	targets := map[*scaleObjectKind]map[string]*scaleObject{jobTypeKind: targetJobTypes}
+	if len(cmd.recipeTypes) != 0 {
+		if targets[recipeTypeKind], err = fetchScaleObjects(recipeTypeKind); err != nil {
+			return err
+		}
+	}
+
+	// job types go first so that the recipe types promoted along with them find them
+	promotions := []*promotion{}
+	problems := []string{}
+	for _, kind := range []*scaleObjectKind{jobTypeKind, recipeTypeKind} {
+		for _, source := range sources[kind] {
+			key := kind.key(sourceAPI, source)
+			remapped, referenced := remapWorkspaces(source, mapping)
+			definition := remapped.(map[string]interface{})
+			for _, name := range referenced {
+				if _, ok := workspaces[name]; !ok {
+					problems = append(problems, fmt.Sprintf("%s %s writes to workspace %s, which the target does not have: create it or map it with --workspace-map", kind.Name, key, name))
+				}
+			}
+			if kind == recipeTypeKind {
+				for _, jobType := range recipeJobTypes(definition["definition"]) {
+					if _, ok := targetJobTypes[jobType]; !ok && !containsString(cmd.jobTypes, jobType) {
+						problems = append(problems, fmt.Sprintf("recipe type %s runs job type %s, which the target does not have: promote it with --job-type %s", key, jobType, jobType))
+					}
+				}
+			}
+
+			action := &applyAction{Kind: kind.Name, Key: key, Action: applyCreate, object: definition}
+			var current map[string]interface{}
+			if target, ok := targets[kind][key]; ok {
+				current = kind.stripServerFields(targetAPI, target.Object)
+				for _, field := range promoteInterfaceFields[kind.Name] {
+					if !optionValuesEqual(current[field], definition[field]) {
+						problems = append(problems, fmt.Sprintf("%s %s already exists on the target with a different %s: publish the change as a new version instead", kind.Name, key, field))
+					}
+				}
+				action.id = target.ID
+				action.Fields = changedFields(current, definition)
+				action.Action = applyNoop
+				if len(action.Fields) != 0 {
+					action.Action = applyUpdate
+				}
+			}
+			promotions = append(promotions, &promotion{action: action, changes: promoteChanges(current, definition)})
+		}
+	}
+
+	client.PrintMessage("Promoting from profile '%s' to profile '%s':\n", cmd.fromProfile, cmd.toProfile)
+	pending := 0
+	for _, p := range promotions {
+		printPromotion(p)
+		if p.action.pending() {
+			pending++
+		}
+	}
+	if len(problems) != 0 {
+		client.PrintMessage("")
+		for _, problem := range problems {
+			client.PrintMessage("ERROR: %s", problem)
+		}
+		return fmt.Errorf("Refusing to promote: %d problems found", len(problems))
+	}
+	if cmd.dryRun || pending == 0 {
+		return nil
+	}
+	if !cmd.yes && !confirmTyped(os.Stdin, fmt.Sprintf("\nThis will change %d definitions on profile '%s'.", pending, cmd.toProfile), cmd.toProfile) {
+		return fmt.Errorf("Promotion cancelled")
+	}
+	for _, p := range promotions {
+		if !p.action.pending() {
+			continue
+		}
+		if err := p.action.carryOut(); err != nil {
+			return fmt.Errorf("Failed to %s %s %s: %s", p.action.Action, p.action.Kind, p.action.Key, err)
+		}
+		client.PrintMessage("%s %s %s: done", p.action.Action, p.action.Kind, p.action.Key)
+	}
+	return nil
+}
+
+func handlePromoteSection(app *kingpin.Application) {
+	cmd := &promoteHandler{}
+	promote := app.Command("promote", "Copy job and recipe type definitions from one Scale instance to another").Action(cmd.handlePromote)
+	promote.Flag("from-profile", "Profile of the instance to copy from").Required().StringVar(&cmd.fromProfile)
+	promote.Flag("to-profile", "Profile of the instance to copy to").Required().StringVar(&cmd.toProfile)
+	promote.Flag("job-type", "Job type to promote, as name:version").StringsVar(&cmd.jobTypes)
+	promote.Flag("recipe-type", "Recipe type to promote, as name:version, or name from Scale API v6").StringsVar(&cmd.recipeTypes)
+	promote.Flag("workspace-map", "JSON, YAML or TOML file mapping source workspace names to target ones").StringVar(&cmd.workspaceMap)
+	promote.Flag("dry-run", "Show the differences without promoting").BoolVar(&cmd.dryRun)
+	promote.Flag("yes", "Do not ask for confirmation").BoolVar(&cmd.yes)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// rabbitmqManagement is a client for the RabbitMQ management HTTP API, which the rabbitmq pod
+// exposes on its management port.
+type rabbitmqManagement struct {
+	BaseURL  string
+	User     string
+	Password string
+	Timeout  time.Duration
+}
+
+func (m *rabbitmqManagement) request(method, path string) ([]byte, error) {
+	url := strings.TrimRight(m.BaseURL, "/") + "/api/" + strings.TrimLeft(path, "/")
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(m.User, m.Password)
+	response, err := (&http.Client{Timeout: m.Timeout}).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return body, &httpError{Method: method, URL: url, StatusCode: response.StatusCode, Body: body}
+	}
+	return body, nil
+}
+
+func (m *rabbitmqManagement) get(path string, target interface{}) error {
+	body, err := m.request("GET", path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("Failed to parse RabbitMQ management response for %s: %s", path, err)
+	}
+	return nil
+}
+
+// rabbitmqRate is a message count with its rate per second over the last sampling period.
+type rabbitmqRate struct {
+	Rate float64 `json:"rate"`
+}
+
+type rabbitmqMessageStats struct {
+	Publish        int64        `json:"publish"`
+	PublishDetails rabbitmqRate `json:"publish_details"`
+	// DeliverGet counts deliveries to consumers and basic.get responses together.
+	DeliverGet        int64        `json:"deliver_get"`
+	DeliverGetDetails rabbitmqRate `json:"deliver_get_details"`
+}
+
+type rabbitmqOverview struct {
+	RabbitMQVersion   string `json:"rabbitmq_version"`
+	ManagementVersion string `json:"management_version"`
+	ClusterName       string `json:"cluster_name"`
+	ObjectTotals      struct {
+		Connections int `json:"connections"`
+		Channels    int `json:"channels"`
+		Queues      int `json:"queues"`
+		Consumers   int `json:"consumers"`
+	} `json:"object_totals"`
+	QueueTotals struct {
+		Messages               int64 `json:"messages"`
+		MessagesReady          int64 `json:"messages_ready"`
+		MessagesUnacknowledged int64 `json:"messages_unacknowledged"`
+	} `json:"queue_totals"`
+	MessageStats rabbitmqMessageStats `json:"message_stats"`
+}
+
+func (m *rabbitmqManagement) overview() (*rabbitmqOverview, error) {
+	var overview rabbitmqOverview
+	if err := m.get("overview", &overview); err != nil {
+		return nil, err
+	}
+	return &overview, nil
+}
+
+type rabbitmqQueue struct {
+	Name                   string               `json:"name" yaml:"name"`
+	VirtualHost            string               `json:"vhost" yaml:"vhost"`
+	Messages               int64                `json:"messages" yaml:"messages"`
+	MessagesReady          int64                `json:"messages_ready" yaml:"messagesReady"`
+	MessagesUnacknowledged int64                `json:"messages_unacknowledged" yaml:"messagesUnacknowledged"`
+	Consumers              int                  `json:"consumers" yaml:"consumers"`
+	MessageStats           rabbitmqMessageStats `json:"message_stats" yaml:"messageStats"`
+}
+
+// queues lists the queues of a virtual host.
+func (m *rabbitmqManagement) queues(vhost string) ([]rabbitmqQueue, error) {
+	queues := []rabbitmqQueue{}
+	if err := m.get("queues/"+url.PathEscape(vhost), &queues); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+func (m *rabbitmqManagement) queue(vhost, name string) (*rabbitmqQueue, error) {
+	var queue rabbitmqQueue
+	if err := m.get(fmt.Sprintf("queues/%s/%s", url.PathEscape(vhost), url.PathEscape(name)), &queue); err != nil {
+		return nil, err
+	}
+	return &queue, nil
+}
+
+// purge drops the ready messages of a queue. Messages delivered but not yet acknowledged stay.
+func (m *rabbitmqManagement) purge(vhost, name string) error {
+	_, err := m.request("DELETE", fmt.Sprintf("queues/%s/%s/contents", url.PathEscape(vhost), url.PathEscape(name)))
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rabbitmqStandIn serves the management API paths the CLI uses for the default vhost.
+func rabbitmqStandIn(purged *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "guest" || password != "guest" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"not_authorised","reason":"Login failed"}`))
+			return
+		}
+		switch r.Method + " " + r.URL.EscapedPath() {
+		case "GET /api/overview":
+			w.Write([]byte(`{"rabbitmq_version":"3.7.8","management_version":"3.7.8","cluster_name":"rabbit@rabbitmq-0",
+				"object_totals":{"connections":3,"channels":4,"queues":2,"consumers":1},
+				"queue_totals":{"messages":12,"messages_ready":10,"messages_unacknowledged":2},
+				"message_stats":{"publish":40,"publish_details":{"rate":1.5},"deliver_get":28,"deliver_get_details":{"rate":0.5}}}`))
+		case "GET /api/queues/%2F":
+			w.Write([]byte(`[{"name":"scale-command-messages","vhost":"/","messages":12,"messages_ready":10,"messages_unacknowledged":2,"consumers":1}]`))
+		case "GET /api/queues/%2F/scale-command-messages":
+			w.Write([]byte(`{"name":"scale-command-messages","vhost":"/","messages":12,"consumers":1}`))
+		case "DELETE /api/queues/%2F/scale-command-messages/contents":
+			*purged = append(*purged, "scale-command-messages")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Object Not Found","reason":"Not Found"}`))
+		}
+	}))
+}
+
+func TestRabbitMQManagement(t *testing.T) {
+	purged := []string{}
+	server := rabbitmqStandIn(&purged)
+	defer server.Close()
+	management := &rabbitmqManagement{BaseURL: server.URL + "/", User: "guest", Password: "guest", Timeout: 5 * time.Second}
+
+	overview, err := management.overview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overview.RabbitMQVersion != "3.7.8" || overview.ObjectTotals.Queues != 2 || overview.MessageStats.PublishDetails.Rate != 1.5 {
+		t.Errorf("overview is %+v", overview)
+	}
+	queues, err := management.queues("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queues) != 1 || queues[0].Name != "scale-command-messages" || queues[0].MessagesReady != 10 {
+		t.Errorf("queues are %+v", queues)
+	}
+	if queue, err := management.queue("/", "scale-command-messages"); err != nil || queue.Consumers != 1 {
+		t.Errorf("queue returned %+v, %v", queue, err)
+	}
+	if _, err := management.queue("/", "missing"); !isStatus(err, 404) {
+		t.Errorf("a missing queue returned %v, expected a 404", err)
+	}
+	if err := management.purge("/", "scale-command-messages"); err != nil || len(purged) != 1 {
+		t.Errorf("purge returned %v, purged %v", err, purged)
+	}
+}
+
+func TestRabbitMQManagementLoginRefused(t *testing.T) {
+	server := rabbitmqStandIn(&[]string{})
+	defer server.Close()
+	management := &rabbitmqManagement{BaseURL: server.URL, User: "guest", Password: "wrong", Timeout: 5 * time.Second}
+	if _, err := management.overview(); !isStatus(err, 401) {
+		t.Errorf("overview with a wrong password returned %v, expected a 401", err)
+	}
+}
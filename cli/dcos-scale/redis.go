@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal Redis client speaking RESP, enough to check that a Redis broker is reachable, accepts
+// the password and has the configured database.
+
+type redisConfig struct {
+	Address  string
+	Password string
+	DB       int
+	// TLS connects over TLS when set.
+	TLS     *tls.Config
+	Timeout time.Duration
+}
+
+// redisHandshake authenticates, selects the database and returns the server's version.
+func redisHandshake(config redisConfig) (string, error) {
+	conn, err := net.DialTimeout("tcp", config.Address, config.Timeout)
+	if err != nil {
+		return "", err
+	}
+	if config.TLS != nil {
+		conn = tls.Client(conn, config.TLS)
+	}
+	defer conn.Close()
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	reader := bufio.NewReader(conn)
+	if len(config.Password) != 0 {
+		if _, err := redisCommand(conn, reader, "AUTH", config.Password); err != nil {
+			return "", fmt.Errorf("authentication failed: %s", err)
+		}
+	}
+	if config.DB != 0 {
+		if _, err := redisCommand(conn, reader, "SELECT", strconv.Itoa(config.DB)); err != nil {
+			return "", fmt.Errorf("cannot select database %d: %s", config.DB, err)
+		}
+	}
+	info, err := redisCommand(conn, reader, "INFO", "server")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "redis_version:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "redis_version:")), nil
+		}
+	}
+	return "unknown", nil
+}
+
+// redisCommand sends a command and returns its simple or bulk string reply.
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (string, error) {
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(w, command); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return "", fmt.Errorf("empty reply, is this a Redis server?")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("%s", line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size > 1<<20 {
+			return "", fmt.Errorf("invalid reply '%s', is this a Redis server?", line)
+		}
+		if size < 0 {
+			return "", nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		return string(data[:size]), nil
+	}
+	return "", fmt.Errorf("unexpected reply '%s', is this a Redis server?", line)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+)
+
+// Scale's REST API has moved between versioned prefixes, and the Scale image a cluster runs comes
+// from the package's resource.json, so the CLI asks each instance which prefix it serves. The
+// prefixes differ in how objects are addressed and what some requests carry, which the adapters
+// below take care of.
+
+// scaleAPIAdapter describes one versioned prefix of the Scale REST API.
+type scaleAPIAdapter struct {
+	// Version is the path prefix, eg v5.
+	Version string
+	// Keys overrides the identity fields of kinds which this version identifies differently.
+	Keys map[string][]string
+	// ServerFields are the fields of each kind this version computes, eg job counts.
+	ServerFields map[string][]string
+	// DetailPath returns the path of one object of a kind.
+	DetailPath func(kind *scaleObjectKind, id string, object map[string]interface{}) string
+	// CreatePayload returns what to POST to create an object, from its full definition.
+	CreatePayload func(kind *scaleObjectKind, object map[string]interface{}) map[string]interface{}
+}
+
+func detailByID(kind *scaleObjectKind, id string, object map[string]interface{}) string {
+	return fmt.Sprintf("%s/%s/", kind.Name, id)
+}
+
+func createAsIs(kind *scaleObjectKind, object map[string]interface{}) map[string]interface{} {
+	return object
+}
+
+// scaleAPIAdapters are the versions the CLI speaks, newest first.
+var scaleAPIAdapters = []scaleAPIAdapter{
+	{
+		Version: "v6",
+		// recipe types lost their version and are revised under one name
+		Keys: map[string][]string{"recipe-types": {"name"}},
+		ServerFields: map[string][]string{
+			"job-types":    {"recipe_types", "unmet_resources"},
+			"recipe-types": {"job_types", "sub_recipe_types"},
+		},
+		DetailPath: func(kind *scaleObjectKind, id string, object map[string]interface{}) string {
+			switch kind.Name {
+			case "job-types":
+				return fmt.Sprintf("job-types/%v/%v/", object["name"], object["version"])
+			case "recipe-types":
+				return fmt.Sprintf("recipe-types/%v/", object["name"])
+			}
+			return detailByID(kind, id, object)
+		},
+		// job types are created from their manifest and recipe types from their title, the other
+		// fields of their details are derived from those
+		CreatePayload: func(kind *scaleObjectKind, object map[string]interface{}) map[string]interface{} {
+			var fields []string
+			switch kind.Name {
+			case "job-types":
+				fields = []string{"icon_code", "is_published", "max_scheduled", "docker_image", "manifest", "configuration"}
+			case "recipe-types":
+				fields = []string{"title", "description", "definition"}
+			default:
+				return object
+			}
+			payload := map[string]interface{}{}
+			for _, field := range fields {
+				if value, ok := object[field]; ok {
+					payload[field] = value
+				}
+			}
+			return payload
+		},
+	},
+	{Version: "v5", ServerFields: legacyServerFields, DetailPath: detailByID, CreatePayload: createAsIs},
+	{Version: "v4", ServerFields: legacyServerFields, DetailPath: detailByID, CreatePayload: createAsIs},
+}
+
+// legacyServerFields are computed by v5 and v4: the job counts and mapped errors of a job type
+// and the job types a recipe type runs.
+var legacyServerFields = map[string][]string{
+	"job-types":    {"errors", "job_counts_6h", "job_counts_12h", "job_counts_24h"},
+	"recipe-types": {"job_types"},
+}
+
+// scaleAPIVersionInfo is the response of a version endpoint.
+type scaleAPIVersionInfo struct {
+	Version string `json:"version"`
+}
+
+// negotiatedScaleAPI is the adapter for the instance of the active profile, once known.
+var negotiatedScaleAPI *scaleAPIAdapter
+
+func scaleAPIVersions() []string {
+	versions := []string{}
+	for _, adapter := range scaleAPIAdapters {
+		versions = append(versions, adapter.Version)
+	}
+	return versions
+}
+
+// scaleAPI returns the adapter for the newest version the instance serves. A profile's
+// api-version skips the detection, eg when the version endpoint is not reachable.
+func scaleAPI() (*scaleAPIAdapter, error) {
+	if negotiatedScaleAPI != nil {
+		return negotiatedScaleAPI, nil
+	}
+	if activeProfile != nil && len(activeProfile.APIVersion) != 0 {
+		for i := range scaleAPIAdapters {
+			if scaleAPIAdapters[i].Version == activeProfile.APIVersion {
+				negotiatedScaleAPI = &scaleAPIAdapters[i]
+				return negotiatedScaleAPI, nil
+			}
+		}
+		return nil, fmt.Errorf("The profile's api-version %s is not supported, expected one of %s",
+			activeProfile.APIVersion, strings.Join(scaleAPIVersions(), ", "))
+	}
+	base := dcosURL() + scaleAPIBasePath()
+	for i := range scaleAPIAdapters {
+		adapter := &scaleAPIAdapters[i]
+		body, err := doRequest("GET", fmt.Sprintf("%s/%s/version/", base, adapter.Version), nil, "")
+		if isStatus(err, 404) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var info scaleAPIVersionInfo
+		if err := json.Unmarshal(body, &info); err != nil {
+			return nil, fmt.Errorf("Failed to parse the Scale version from %s/%s/version/: %s", base, adapter.Version, err)
+		}
+		if config.Verbose {
+			client.PrintMessage("Scale %s serves API %s", info.Version, adapter.Version)
+		}
+		negotiatedScaleAPI = adapter
+		return adapter, nil
+	}
+	return nil, fmt.Errorf("The Scale API at %s serves none of the API versions %s which this CLI supports",
+		base, strings.Join(scaleAPIVersions(), ", "))
+}
+
+// scaleAPIURL returns the URL of a Scale REST API path, eg "queue/status/", under the version
+// the instance serves.
+func scaleAPIURL(path string) (string, error) {
+	adapter, err := scaleAPI()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s/%s/%s", dcosURL(), scaleAPIBasePath(), adapter.Version, strings.TrimLeft(path, "/")), nil
+}
+
+func scaleAPIRequest(method, path string, payload []byte) ([]byte, error) {
+	url, err := scaleAPIURL(path)
+	if err != nil {
+		return nil, err
+	}
+	contentType := ""
+	if payload != nil {
+		contentType = "application/json"
+	}
+	return doRequest(method, url, payload, contentType)
+}
+
+func scaleAPIGet(path string, target interface{}) error {
+	body, err := scaleAPIRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("Failed to parse response from Scale API %s: %s", path, err)
+	}
+	return nil
+}
+
+// scaleQueueStatus is the depth of the Scale queue per job type.
+type scaleQueueStatus struct {
+	Count   int `json:"count"`
+	Results []struct {
+		JobType struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"job_type"`
+		Count int `json:"count"`
+	} `json:"results"`
+}
+
+func (s *scaleQueueStatus) queued() int {
+	total := 0
+	for _, result := range s.Results {
+		total += result.Count
+	}
+	return total
+}
+
+// scaleAPIList returns all results of a paginated Scale API list, eg "workspaces/".
+func scaleAPIList(path string) ([]json.RawMessage, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	url, err := scaleAPIURL(path)
+	if err != nil {
+		return nil, err
+	}
+	url += separator + "page_size=1000"
+	results := []json.RawMessage{}
+	for len(url) != 0 {
+		body, err := doRequest("GET", url, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Next    string            `json:"next"`
+			Results []json.RawMessage `json:"results"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("Failed to parse response from Scale API %s: %s", path, err)
+		}
+		results = append(results, page.Results...)
+		url = page.Next
+	}
+	return results, nil
+}
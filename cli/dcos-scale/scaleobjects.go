@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Scale's configuration is made of a few kinds of objects, which are exported to and applied from
+// one directory per kind holding one YAML file per object. Objects are identified across
+// instances by their name, and job and recipe types by name and version, since ids differ from
+// one instance to the next.
+
+type scaleObjectKind struct {
+	// Name is the directory the objects are kept in and the API path they are listed under.
+	Name string
+	// Keys are the fields which identify an object.
+	Keys []string
+	// ServerFields are fields Scale manages itself under every API version, which are left out of
+	// exports and ignored when comparing objects. Those of one version are in its adapter.
+	ServerFields []string
+	// Retire is the update which takes an object out of use, for kinds Scale allows that for.
+	// Scale never deletes configuration, as jobs and recipes which ran keep referring to it.
+	Retire map[string]interface{}
+	// Reactivate is the update which puts a retired object back into use.
+	Reactivate map[string]interface{}
+}
+
+// scaleObjectKinds are in dependency order: job types read from and write to workspaces, recipe
+// types are made of job types and Strikes and Scans feed workspaces into recipes.
+var scaleObjectKinds = []scaleObjectKind{
+	{Name: "workspaces", Keys: []string{"name"}, ServerFields: []string{"is_active", "used_size", "total_size"}, Retire: map[string]interface{}{"is_active": false}, Reactivate: map[string]interface{}{"is_active": true}},
+	{Name: "job-types", Keys: []string{"name", "version"}, ServerFields: []string{"revision_num", "is_system", "is_active", "archived", "paused"}, Retire: map[string]interface{}{"is_active": false}, Reactivate: map[string]interface{}{"is_active": true}},
+	{Name: "recipe-types", Keys: []string{"name", "version"}, ServerFields: []string{"revision_num", "is_system", "is_active", "archived"}, Retire: map[string]interface{}{"is_active": false}, Reactivate: map[string]interface{}{"is_active": true}},
+	{Name: "strikes", Keys: []string{"name"}, ServerFields: []string{"job"}},
+	{Name: "scans", Keys: []string{"name"}, ServerFields: []string{"job", "dry_run_job", "file_count"}},
+}
+
+// scaleServerFields are managed by Scale on every object, including nested ones.
+var scaleServerFields = []string{"id", "created", "last_modified", "deprecated"}
+
+func scaleObjectKindNamed(name string) (*scaleObjectKind, error) {
+	for i := range scaleObjectKinds {
+		if scaleObjectKinds[i].Name == name {
+			return &scaleObjectKinds[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown kind %s", name)
+}
+
+// keyFields returns the fields which identify an object under the adapter's API version.
+func (k *scaleObjectKind) keyFields(adapter *scaleAPIAdapter) []string {
+	if keys, ok := adapter.Keys[k.Name]; ok {
+		return keys
+	}
+	return k.Keys
+}
+
+// key returns the identity of an object, eg "my-job:1.0.0".
+func (k *scaleObjectKind) key(adapter *scaleAPIAdapter, object map[string]interface{}) string {
+	parts := []string{}
+	for _, field := range k.keyFields(adapter) {
+		parts = append(parts, fmt.Sprint(object[field]))
+	}
+	return strings.Join(parts, ":")
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileName returns the file an object is kept in, eg my-job-1.0.0.yaml.
+func (k *scaleObjectKind) fileName(adapter *scaleAPIAdapter, object map[string]interface{}) string {
+	return unsafeFileChars.ReplaceAllString(strings.Replace(k.key(adapter, object), ":", "-", -1), "_") + ".yaml"
+}
+
+// stripServerFields returns a copy of the object without the fields Scale manages, including
+// those the API version of the adapter computes.
+func (k *scaleObjectKind) stripServerFields(adapter *scaleAPIAdapter, object map[string]interface{}) map[string]interface{} {
+	stripped := stripFields(object, scaleServerFields).(map[string]interface{})
+	for _, field := range append(k.ServerFields, adapter.ServerFields[k.Name]...) {
+		delete(stripped, field)
+	}
+	return stripped
+}
+
+func stripFields(value interface{}, fields []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		stripped := map[string]interface{}{}
+		for key, child := range v {
+			if !containsString(fields, key) {
+				stripped[key] = stripFields(child, fields)
+			}
+		}
+		return stripped
+	case []interface{}:
+		stripped := make([]interface{}, len(v))
+		for i, child := range v {
+			stripped[i] = stripFields(child, fields)
+		}
+		return stripped
+	}
+	return value
+}
+
+// scaleObject is an object of the live Scale instance, with its id for updates.
+type scaleObject struct {
+	ID     string
+	Object map[string]interface{}
+}
+
+// fetchScaleObjects returns the objects of a kind, with their full details, keyed by identity.
+func fetchScaleObjects(kind *scaleObjectKind) (map[string]*scaleObject, error) {
+	adapter, err := scaleAPI()
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := scaleAPIList(kind.Name + "/")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list %s: %s", kind.Name, err)
+	}
+	objects := map[string]*scaleObject{}
+	for _, summary := range summaries {
+		var listed map[string]interface{}
+		if err := json.Unmarshal(summary, &listed); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %s", kind.Name, err)
+		}
+		id := optionString(listed, "id", "")
+		// lists only hold summaries, the details hold the whole configuration
+		var detail map[string]interface{}
+		if err := scaleAPIGet(adapter.DetailPath(kind, id, listed), &detail); err != nil {
+			return nil, fmt.Errorf("Failed to read %s %s: %s", kind.Name, kind.key(adapter, listed), err)
+		}
+		objects[kind.key(adapter, detail)] = &scaleObject{ID: id, Object: detail}
+	}
+	return objects, nil
+}
+
+func sortedObjectKeys(objects map[string]*scaleObject) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonValue converts a value decoded from YAML into the types JSON decoding produces, so that
+// objects read from files compare equal to those from the API.
+func jsonValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(stringKeys(value))
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// stringKeys replaces the map[interface{}]interface{} maps of yaml.v2 with string keyed ones.
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, child := range v {
+			converted[fmt.Sprint(key)] = stringKeys(child)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, child := range v {
+			converted[i] = stringKeys(child)
+		}
+		return converted
+	}
+	return value
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// liveScaleObjects are details as each API version returns them, with the fields Scale computes.
+var liveScaleObjects = map[string]map[string]string{
+	"v6": {
+		"workspaces": `[{"id": 1, "name": "raw", "title": "Raw", "base_url": null, "is_active": true, "used_size": 1024.5, "total_size": 4096,
+			"created": "2018-09-01T10:00:00Z", "deprecated": null, "last_modified": "2018-09-01T10:00:00Z",
+			"configuration": {"broker": {"type": "host", "host_path": "/data", "volume": null}}},
+			{"id": 2, "name": "old", "title": "Old", "base_url": null, "is_active": false, "used_size": 0, "total_size": 0,
+			"created": "2018-08-01T10:00:00Z", "deprecated": "2018-09-01T10:00:00Z", "last_modified": "2018-09-01T10:00:00Z",
+			"configuration": {"broker": {"type": "host", "host_path": "/old"}}}]`,
+		"job-types": `[{"id": 7, "name": "ingest", "version": "1.0.0", "title": "Ingest", "is_active": true, "is_paused": false, "is_published": true,
+			"is_system": false, "revision_num": 3, "max_scheduled": null, "max_tries": 3, "docker_image": "geoint/ingest:1.0.0", "icon_code": "f013",
+			"manifest": {"seedVersion": "1.0.0", "job": {"name": "ingest", "jobVersion": "1.0.0", "interface": {"command": "ingest ${INPUT}", "inputs": {"files": [{"name": "INPUT"}]}}}},
+			"configuration": {"output_workspaces": {"default": "raw"}, "priority": 100},
+			"recipe_types": [{"id": 2, "name": "ingest-recipe", "title": "Ingest", "revision_num": 1}],
+			"unmet_resources": "chocolate,vanilla",
+			"created": "2018-09-01T10:00:00Z", "deprecated": null, "paused": null, "last_modified": "2018-09-02T10:00:00Z"}]`,
+		"recipe-types": `[{"id": 2, "name": "ingest-recipe", "title": "Ingest", "description": "", "is_active": true, "is_system": false, "revision_num": 1,
+			"definition": {"input": {"files": [{"name": "INPUT", "required": true}]}, "nodes": {"ingest": {"dependencies": [], "input": {},
+				"node_type": {"node_type": "job", "job_type_name": "ingest", "job_type_version": "1.0.0", "job_type_revision": 3}}}},
+			"job_types": [{"id": 7, "name": "ingest", "version": "1.0.0"}], "sub_recipe_types": [],
+			"created": "2018-09-01T10:00:00Z", "deprecated": null, "last_modified": "2018-09-01T10:00:00Z"}]`,
+		"strikes": `[{"id": 3, "name": "landing", "title": "Landing", "description": null, "job": {"id": 99, "status": "RUNNING"},
+			"configuration": {"workspace": "raw", "monitor": {"type": "dir-watcher", "transfer_suffix": "_tmp"}, "files_to_ingest": [{"filename_regex": ".*"}]},
+			"created": "2018-09-01T10:00:00Z", "last_modified": "2018-09-01T10:00:00Z"}]`,
+		"scans": `[]`,
+	},
+	"v5": {
+		"workspaces": `[{"id": 1, "name": "raw", "title": "Raw", "base_url": null, "is_active": true, "used_size": 1024, "total_size": 4096,
+			"created": "2018-09-01T10:00:00Z", "archived": null, "last_modified": "2018-09-01T10:00:00Z",
+			"json_config": {"version": "1.0", "broker": {"type": "host", "host_path": "/data"}}}]`,
+		"job-types": `[{"id": 7, "name": "ingest", "version": "1.0.0", "title": "Ingest", "category": "ingest", "is_active": true, "is_system": false,
+			"is_operational": true, "is_long_running": false, "revision_num": 3, "priority": 100, "max_tries": 3, "cpus_required": 1.5, "mem_const_required": 512.0,
+			"interface": {"version": "1.0", "command": "ingest", "command_arguments": "${INPUT}", "input_data": [{"name": "INPUT", "type": "file"}]},
+			"error_mapping": {"version": "1.0", "exit_codes": {"1": "bad-file"}}, "trigger_rule": null,
+			"errors": [{"id": 4, "name": "bad-file", "category": "DATA"}],
+			"job_counts_6h": [{"status": "COMPLETED", "count": 10, "most_recent": "2018-09-02T10:00:00Z", "category": null}],
+			"job_counts_12h": [{"status": "COMPLETED", "count": 20, "most_recent": "2018-09-02T10:00:00Z", "category": null}],
+			"job_counts_24h": [{"status": "FAILED", "count": 1, "most_recent": "2018-09-02T09:00:00Z", "category": "DATA"}],
+			"created": "2018-09-01T10:00:00Z", "archived": null, "paused": null, "last_modified": "2018-09-02T10:00:00Z"}]`,
+		"recipe-types": `[{"id": 2, "name": "ingest-recipe", "version": "1.0.0", "title": "Ingest", "description": "", "is_active": true, "revision_num": 1,
+			"definition": {"version": "1.0", "input_data": [{"name": "INPUT", "type": "file"}], "jobs": [{"name": "ingest", "job_type": {"name": "ingest", "version": "1.0.0"}}]},
+			"job_types": [{"id": 7, "name": "ingest", "version": "1.0.0"}], "trigger_rule": null,
+			"created": "2018-09-01T10:00:00Z", "archived": null, "last_modified": "2018-09-01T10:00:00Z"}]`,
+		"strikes": `[]`,
+		"scans": `[{"id": 5, "name": "backfill", "title": "Backfill", "description": "", "job": null, "dry_run_job": {"id": 98}, "file_count": 1200,
+			"configuration": {"version": "1.0", "workspace": "raw", "scanner": {"type": "dir"}, "recursive": true, "files_to_ingest": [{"filename_regex": ".*"}]},
+			"created": "2018-09-01T10:00:00Z", "last_modified": "2018-09-01T10:00:00Z"}]`,
+	},
+}
+
+// laterScaleObjects are the computed values which change as the instance runs jobs and scans.
+var laterScaleObjects = map[string]map[string]map[string]interface{}{
+	"v6": {
+		"workspaces":   {"used_size": 2048.0, "last_modified": "2018-09-03T10:00:00Z"},
+		"job-types":    {"recipe_types": []interface{}{}, "unmet_resources": nil},
+		"recipe-types": {"job_types": []interface{}{}},
+		"strikes":      {"job": map[string]interface{}{"id": 100.0, "status": "FAILED"}},
+	},
+	"v5": {
+		"workspaces":   {"used_size": 2048.0, "total_size": 8192.0},
+		"job-types":    {"errors": []interface{}{}, "job_counts_6h": []interface{}{}, "job_counts_24h": nil},
+		"recipe-types": {"job_types": []interface{}{}},
+		"scans":        {"job": map[string]interface{}{"id": 101.0}, "file_count": 1300.0},
+	},
+}
+
+func scaleAPIAdapterNamed(t *testing.T, version string) *scaleAPIAdapter {
+	for i := range scaleAPIAdapters {
+		if scaleAPIAdapters[i].Version == version {
+			return &scaleAPIAdapters[i]
+		}
+	}
+	t.Fatalf("no adapter for %s", version)
+	return nil
+}
+
+// fetchLiveObjects returns a fetch function serving the live objects of one API version, as
+// exported or, later, with their computed values changed.
+func fetchLiveObjects(t *testing.T, adapter *scaleAPIAdapter, later bool) func(kind *scaleObjectKind) (map[string]*scaleObject, error) {
+	return func(kind *scaleObjectKind) (map[string]*scaleObject, error) {
+		var listed []map[string]interface{}
+		if err := json.Unmarshal([]byte(liveScaleObjects[adapter.Version][kind.Name]), &listed); err != nil {
+			t.Fatalf("%s %s: %s", adapter.Version, kind.Name, err)
+		}
+		objects := map[string]*scaleObject{}
+		for _, object := range listed {
+			if later {
+				for field, value := range laterScaleObjects[adapter.Version][kind.Name] {
+					object[field] = value
+				}
+			}
+			objects[kind.key(adapter, object)] = &scaleObject{ID: optionString(object, "id", ""), Object: object}
+		}
+		return objects, nil
+	}
+}
+
+func TestExportThenApplyIsNoop(t *testing.T) {
+	for _, version := range []string{"v6", "v5"} {
+		adapter := scaleAPIAdapterNamed(t, version)
+		dir, err := ioutil.TempDir("", "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := exportScaleObjects(adapter, dir, fetchLiveObjects(t, adapter, false)); err != nil {
+			t.Fatal(err)
+		}
+		actions, err := planApply(adapter, dir, true, fetchLiveObjects(t, adapter, true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actions) == 0 {
+			t.Errorf("%s: nothing was exported", version)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "workspaces", "old.yaml")); !os.IsNotExist(err) {
+			t.Errorf("%s: the retired workspace was exported", version)
+		}
+		for _, action := range actions {
+			if action.Action != applyNoop {
+				t.Errorf("%s: %s %s %s %v, expected a no-op", version, action.Action, action.Kind, action.Key, action.Fields)
+			}
+		}
+	}
+}
+
+func TestStripServerFieldsPerVersion(t *testing.T) {
+	kind, _ := scaleObjectKindNamed("job-types")
+	object := map[string]interface{}{"name": "ingest", "errors": []interface{}{}, "recipe_types": []interface{}{}, "job_counts_6h": []interface{}{}}
+	v5 := kind.stripServerFields(scaleAPIAdapterNamed(t, "v5"), object)
+	if _, ok := v5["errors"]; ok {
+		t.Errorf("v5 kept the mapped errors: %v", v5)
+	}
+	if _, ok := v5["job_counts_6h"]; ok {
+		t.Errorf("v5 kept the job counts: %v", v5)
+	}
+	v6 := kind.stripServerFields(scaleAPIAdapterNamed(t, "v6"), object)
+	if _, ok := v6["recipe_types"]; ok {
+		t.Errorf("v6 kept the recipe types: %v", v6)
+	}
+	// v6 job types have no errors field of their own, so one in a file is left to the API to refuse
+	if _, ok := v6["errors"]; !ok {
+		t.Errorf("v6 dropped a field it does not compute: %v", v6)
+	}
+}
+
+func TestKeyPerVersion(t *testing.T) {
+	kind, _ := scaleObjectKindNamed("recipe-types")
+	object := map[string]interface{}{"name": "ingest-recipe", "version": "1.0.0"}
+	if key := kind.key(scaleAPIAdapterNamed(t, "v5"), object); key != "ingest-recipe:1.0.0" {
+		t.Errorf("v5 key is %s", key)
+	}
+	if key := kind.key(scaleAPIAdapterNamed(t, "v6"), object); key != "ingest-recipe" {
+		t.Errorf("v6 key is %s", key)
+	}
+	if name := kind.fileName(scaleAPIAdapterNamed(t, "v4"), object); name != "ingest-recipe-1.0.0.yaml" {
+		t.Errorf("v4 file name is %s", name)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// The scheduler pods are the only ones whose count is configurable, through node.count which
+// marathon.json.mustache renders into NODE_COUNT. Before changing it, scale-out checks that the
+// new pods can be placed: that the placement constraint allows that many pods and that enough
+// agents have the node.cpus and node.mem they ask for.
+
+// schedulerPlacement is the outcome of checking where new scheduler pods can go.
+type schedulerPlacement struct {
+	// MaxPods is the most scheduler pods the placement constraint allows on the active agents, or
+	// -1 when it does not limit them.
+	MaxPods int
+	// Placeable is how many of the new pods the simulation could place.
+	Placeable int
+}
+
+// placeSchedulers simulates placing new scheduler pods asking for demand next to the running ones.
+func placeSchedulers(state *mesosState, constraint string, demand mesosResources, added int) (*schedulerPlacement, error) {
+	rules, err := parsePlacementConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	agents := state.activeAgents()
+	free := map[string]mesosResources{}
+	for _, agent := range agents {
+		free[agent.ID] = agent.free()
+	}
+	placed := []*mesosAgent{}
+	for _, task := range state.serviceTasks("scheduler-") {
+		if agent := state.agent(task.SlaveID); agent != nil {
+			placed = append(placed, agent)
+		}
+	}
+	result := &schedulerPlacement{MaxPods: placementLimit(rules, agents)}
+	for _, placement := range simulatePlacement("scheduler", added, demand, rules, agents, placed, free) {
+		if len(placement.Agent) != 0 {
+			result.Placeable++
+		}
+	}
+	return result, nil
+}
+
+// phaseCompleteCondition holds once the phase of a plan has the given number of steps and all of
+// them are COMPLETE.
+func phaseCompleteCondition(planName, phaseName string, steps int) waitCondition {
+	return func() (bool, string, error) {
+		current, err := fetchPlan(planName)
+		if err != nil {
+			return false, "", apiFailure(err)
+		}
+		if current.Status == "ERROR" {
+			return false, "", &waitFailure{code: exitWaitPlanError, err: fmt.Errorf("Plan %s failed: %v", planName, current.Errors)}
+		}
+		for _, phase := range current.Phases {
+			if phase.Name != phaseName {
+				continue
+			}
+			complete := 0
+			for _, step := range phase.Steps {
+				if step.Status == "COMPLETE" {
+					complete++
+				}
+			}
+			state := fmt.Sprintf("%s: %d of %d steps complete", phaseName, complete, steps)
+			return len(phase.Steps) == steps && complete == steps, state, nil
+		}
+		return false, fmt.Sprintf("waiting for phase %s", phaseName), nil
+	}
+}
+
+type scaleOutHandler struct {
+	schedulers int
+	plan       string
+	yes        bool
+	noWait     bool
+	timeout    time.Duration
+	interval   time.Duration
+}
+
+func (cmd *scaleOutHandler) handleScaleOut(c *kingpin.ParseContext) error {
+	current, err := describeServiceOptions()
+	if err != nil {
+		return err
+	}
+	options := flattenOptions(current)
+	count, err := optionFloat(options, "node.count", 1)
+	if err != nil {
+		return err
+	}
+	currentCount := int(count)
+	if cmd.schedulers == currentCount {
+		client.PrintMessage("Service %s already runs %d scheduler pods.", config.ServiceName, currentCount)
+		return nil
+	}
+	if cmd.schedulers < currentCount {
+		return fmt.Errorf("Scaling in from %d to %d scheduler pods is not supported: the scheduler does not decommission pods", currentCount, cmd.schedulers)
+	}
+
+	demand := mesosResources{}
+	if demand.CPUs, err = optionFloat(options, "node.cpus", 0); err != nil {
+		return err
+	}
+	if demand.Mem, err = optionFloat(options, "node.mem", 0); err != nil {
+		return err
+	}
+	constraint := optionString(options, "node.placement_constraint", "")
+	state, err := fetchMesosState()
+	if err != nil {
+		return err
+	}
+	added := cmd.schedulers - currentCount
+	placement, err := placeSchedulers(state, constraint, demand, added)
+	if err != nil {
+		return err
+	}
+	if placement.MaxPods >= 0 && cmd.schedulers > placement.MaxPods {
+		return fmt.Errorf("The placement constraint '%s' allows at most %d scheduler pods on the %d active agents, not %d",
+			constraint, placement.MaxPods, len(state.activeAgents()), cmd.schedulers)
+	}
+	if placement.Placeable < added {
+		return fmt.Errorf("Only %d of the %d new scheduler pods (%.2f cpus, %.0f MB each) fit into the free resources of agents allowed by the placement constraint",
+			placement.Placeable, added, demand.CPUs, demand.Mem)
+	}
+	client.PrintMessage("The %d new scheduler pods (%.2f cpus, %.0f MB each) fit on the cluster.", added, demand.CPUs, demand.Mem)
+
+	newOptions := map[string]interface{}{"node": map[string]interface{}{"count": cmd.schedulers}}
+	printUpdatePreview(previewUpdate(current, newOptions))
+	if !cmd.yes && !confirmTyped(os.Stdin, fmt.Sprintf("\nThis will scale %s out to %d scheduler pods.", config.ServiceName, cmd.schedulers), config.ServiceName) {
+		return fmt.Errorf("Scale-out cancelled")
+	}
+	if err := updateServiceOptions(newOptions); err != nil {
+		return err
+	}
+	if cmd.noWait {
+		client.PrintMessage("Update started. Follow it with 'dcos scale plan watch %s'.", cmd.plan)
+		return nil
+	}
+	code, err := waitFor(phaseCompleteCondition(cmd.plan, "scheduler-deploy", cmd.schedulers), cmd.timeout, cmd.interval)
+	if code != 0 {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(code)
+	}
+	client.PrintMessage("Service %s runs %d scheduler pods.", config.ServiceName, cmd.schedulers)
+	return nil
+}
+
+func handleScaleOutSection(app *kingpin.Application) {
+	cmd := &scaleOutHandler{}
+	scaleOut := app.Command("scale-out", "Change the number of scheduler pods, checking placement and resources first").Action(cmd.handleScaleOut)
+	scaleOut.Flag("schedulers", "Number of scheduler pods to run").Required().IntVar(&cmd.schedulers)
+	scaleOut.Flag("plan", "Plan which deploys the scheduler pods").Default("scale-deploy").StringVar(&cmd.plan)
+	scaleOut.Flag("yes", "Do not ask for confirmation").BoolVar(&cmd.yes)
+	scaleOut.Flag("no-wait", "Return once the update is started").BoolVar(&cmd.noWait)
+	scaleOut.Flag("timeout", "Time to wait for the new pods").Default("20m").DurationVar(&cmd.timeout)
+	scaleOut.Flag("interval", "Time between polls").Default("5s").DurationVar(&cmd.interval)
+}
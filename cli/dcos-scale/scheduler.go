@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// A rolling restart of the scheduler pods pauses Scale scheduling first, so that no job is
+// being launched while a scheduler goes away. Its progress is kept in ~/.dcos-scale-restart.json
+// until it completes, so that a restart which was interrupted can be picked up with --resume.
+
+const restartStateFileName = ".dcos-scale-restart.json"
+
+// exitInterrupted is used for a waitFailure caused by Ctrl-C.
+const exitInterrupted = 130
+
+type schedulerState struct {
+	IsPaused bool `json:"is_paused"`
+}
+
+// scaleStatus holds the parts of the Scale status/ response needed to tell when scheduling has
+// settled.
+type scaleStatus struct {
+	Scheduler struct {
+		State struct {
+			Name string `json:"name"`
+		} `json:"state"`
+	} `json:"scheduler"`
+	Nodes []struct {
+		Hostname      string `json:"hostname"`
+		JobExecutions struct {
+			Running struct {
+				Total int `json:"total"`
+			} `json:"running"`
+		} `json:"job_executions"`
+	} `json:"nodes"`
+}
+
+func (s *scaleStatus) runningExecutions() int {
+	total := 0
+	for _, node := range s.Nodes {
+		total += node.JobExecutions.Running.Total
+	}
+	return total
+}
+
+func setSchedulingPaused(paused bool) error {
+	payload, err := json.Marshal(schedulerState{IsPaused: paused})
+	if err != nil {
+		return err
+	}
+	_, err = scaleAPIRequest("PATCH", "scheduler/", payload)
+	return err
+}
+
+// schedulingSettledCondition holds once the scheduler reports that it is paused and the number of
+// running job executions stayed the same between two polls, ie no more launches are in flight.
+func schedulingSettledCondition() waitCondition {
+	last := -1
+	return func() (bool, string, error) {
+		var status scaleStatus
+		if err := scaleAPIGet("status/", &status); err != nil {
+			return false, "", apiFailure(err)
+		}
+		running := status.runningExecutions()
+		settled := status.Scheduler.State.Name == "PAUSED" && running == last
+		last = running
+		return settled, fmt.Sprintf("scheduler is %s with %d running job executions", status.Scheduler.State.Name, running), nil
+	}
+}
+
+// restartState is the progress of a rolling restart.
+type restartState struct {
+	ServiceName string `json:"serviceName"`
+	// PausedByRestart is whether scheduling was running before and so is to be resumed at the end.
+	PausedByRestart bool `json:"pausedByRestart"`
+	// Restarting is a pod whose restart was requested but not seen to complete.
+	Restarting string   `json:"restarting,omitempty"`
+	Remaining  []string `json:"remaining"`
+	Done       []string `json:"done"`
+}
+
+func restartStatePath() string {
+	return homePath(restartStateFileName)
+}
+
+func loadRestartState() (*restartState, error) {
+	data, err := ioutil.ReadFile(restartStatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No interrupted rolling restart to resume (%s does not exist)", restartStatePath())
+		}
+		return nil, err
+	}
+	var state restartState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", restartStatePath(), err)
+	}
+	if state.ServiceName != config.ServiceName {
+		return nil, fmt.Errorf("The interrupted rolling restart in %s is of service %s, not %s", restartStatePath(), state.ServiceName, config.ServiceName)
+	}
+	return &state, nil
+}
+
+func (s *restartState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(restartStatePath(), data, 0600)
+}
+
+type schedulerHandler struct {
+	resume     bool
+	podTimeout time.Duration
+	apiTimeout time.Duration
+	interval   time.Duration
+	interrupts chan os.Signal
+}
+
+func (cmd *schedulerHandler) step(format string, a ...interface{}) {
+	client.PrintMessage("==> "+format, a...)
+}
+
+// interruptible fails the condition with exitInterrupted once Ctrl-C was pressed.
+func (cmd *schedulerHandler) interruptible(condition waitCondition) waitCondition {
+	return func() (bool, string, error) {
+		select {
+		case <-cmd.interrupts:
+			return false, "", &waitFailure{code: exitInterrupted, err: fmt.Errorf("Interrupted")}
+		default:
+		}
+		return condition()
+	}
+}
+
+func (cmd *schedulerHandler) waitFor(condition waitCondition, timeout time.Duration) error {
+	_, err := waitFor(cmd.interruptible(condition), timeout, cmd.interval)
+	return err
+}
+
+func (cmd *schedulerHandler) handleRollingRestart(c *kingpin.ParseContext) error {
+	var state *restartState
+	var err error
+	if cmd.resume {
+		if state, err = loadRestartState(); err != nil {
+			return err
+		}
+		cmd.step("Resuming rolling restart, %d of %d scheduler pods restarted", len(state.Done), len(state.Done)+len(state.Remaining))
+	} else {
+		if _, err := os.Stat(restartStatePath()); err == nil {
+			return fmt.Errorf("An interrupted rolling restart is recorded in %s, continue it with --resume or delete the file", restartStatePath())
+		}
+		pods, err := listPods("scheduler")
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			return fmt.Errorf("Service %s has no scheduler pods", config.ServiceName)
+		}
+		var scheduler schedulerState
+		if err := scaleAPIGet("scheduler/", &scheduler); err != nil {
+			return err
+		}
+		state = &restartState{ServiceName: config.ServiceName, PausedByRestart: !scheduler.IsPaused, Remaining: pods, Done: []string{}}
+		cmd.step("Rolling restart of %s", strings.Join(pods, ", "))
+	}
+
+	cmd.interrupts = make(chan os.Signal, 1)
+	signal.Notify(cmd.interrupts, os.Interrupt)
+	defer signal.Stop(cmd.interrupts)
+
+	if err := cmd.rollingRestart(state); err != nil {
+		if failure, ok := err.(*waitFailure); ok && failure.code == exitInterrupted {
+			if saveErr := state.save(); saveErr != nil {
+				return fmt.Errorf("Interrupted, and failed to record progress in %s: %s", restartStatePath(), saveErr)
+			}
+			client.PrintMessage("Interrupted. Scheduling is left paused and the progress is recorded in %s.", restartStatePath())
+			client.PrintMessage("Run 'dcos scale scheduler rolling-restart --resume' to continue.")
+			os.Exit(exitInterrupted)
+		}
+		if saveErr := state.save(); saveErr == nil {
+			client.PrintMessage("Progress is recorded in %s, continue with --resume once the problem is fixed.", restartStatePath())
+		}
+		return err
+	}
+	os.Remove(restartStatePath())
+	return nil
+}
+
+func (cmd *schedulerHandler) rollingRestart(state *restartState) error {
+	if len(state.Done) == 0 && len(state.Restarting) == 0 {
+		cmd.step("Pausing Scale scheduling")
+		if err := setSchedulingPaused(true); err != nil {
+			return err
+		}
+		// record the pause right away, so that an interrupted restart always resumes scheduling
+		if err := state.save(); err != nil {
+			return err
+		}
+	}
+	cmd.step("Waiting for in-progress task launches to settle")
+	if err := cmd.waitFor(schedulingSettledCondition(), cmd.apiTimeout); err != nil {
+		return err
+	}
+
+	if len(state.Restarting) != 0 {
+		cmd.step("Waiting for %s, whose restart was requested before the interruption", state.Restarting)
+		if err := cmd.waitFor(podRunningCondition(state.Restarting), cmd.podTimeout); err != nil {
+			return err
+		}
+		state.Done = append(state.Done, state.Restarting)
+		state.Restarting = ""
+	}
+	for len(state.Remaining) != 0 {
+		pod := state.Remaining[0]
+		status, err := fetchPodStatus(pod)
+		if err != nil {
+			return err
+		}
+		cmd.step("Restarting %s (%d of %d)", pod, len(state.Done)+1, len(state.Done)+len(state.Remaining))
+		if err := podCommand(pod, "restart"); err != nil {
+			return err
+		}
+		state.Restarting, state.Remaining = pod, state.Remaining[1:]
+		if err := state.save(); err != nil {
+			return err
+		}
+		if err := cmd.waitFor(podRelaunchedCondition(pod, status.taskIDs()), cmd.podTimeout); err != nil {
+			return err
+		}
+		cmd.step("Waiting for the Scale API")
+		if err := cmd.waitFor(scaleAPIReadyCondition, cmd.apiTimeout); err != nil {
+			return err
+		}
+		state.Done = append(state.Done, pod)
+		state.Restarting = ""
+		if err := state.save(); err != nil {
+			return err
+		}
+	}
+
+	if state.PausedByRestart {
+		cmd.step("Resuming Scale scheduling")
+		if err := setSchedulingPaused(false); err != nil {
+			return err
+		}
+	} else {
+		cmd.step("Scheduling was paused before the restart and is left paused")
+	}
+	cmd.step("Restarted %s", strings.Join(state.Done, ", "))
+	return nil
+}
+
+func (cmd *schedulerHandler) handlePause(c *kingpin.ParseContext) error {
+	if err := setSchedulingPaused(true); err != nil {
+		return err
+	}
+	client.PrintMessage("Scale scheduling is paused.")
+	return nil
+}
+
+func (cmd *schedulerHandler) handleResume(c *kingpin.ParseContext) error {
+	if err := setSchedulingPaused(false); err != nil {
+		return err
+	}
+	client.PrintMessage("Scale scheduling is resumed.")
+	return nil
+}
+
+func handleSchedulerSection(app *kingpin.Application) {
+	cmd := &schedulerHandler{}
+	scheduler := app.Command("scheduler", "Manage the Scale scheduler")
+
+	restart := scheduler.Command("rolling-restart", "Restart the scheduler pods one at a time with Scale scheduling paused").Action(cmd.handleRollingRestart)
+	restart.Flag("resume", "Continue an interrupted rolling restart").BoolVar(&cmd.resume)
+	restart.Flag("pod-timeout", "Time to wait for each pod to be RUNNING again").Default(defaultPodTimeout.String()).DurationVar(&cmd.podTimeout)
+	restart.Flag("api-timeout", "Time to wait for scheduling to settle and for the Scale API after each restart").Default("5m").DurationVar(&cmd.apiTimeout)
+	restart.Flag("interval", "Time between polls").Default("5s").DurationVar(&cmd.interval)
+
+	scheduler.Command("pause", "Pause Scale scheduling").Action(cmd.handlePause)
+	scheduler.Command("resume", "Resume Scale scheduling").Action(cmd.handleResume)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// secretOption describes an option whose value may be kept in the DC/OS secret store rather than
+// in the plaintext Marathon environment.
+type secretOption struct {
+	// Option is the plaintext options key, eg "db.db-pass".
+	Option string
+	// SecretOption is the options key which holds the secret path instead, eg "db.db-pass-secret".
+	SecretOption string
+	// Generate is whether rotate may generate a random value when none is provided.
+	Generate bool
+	// Change is run by rotate before the new value is stored, to make whatever checks the value
+	// accept it. It may be nil.
+	Change func(options map[string]interface{}, current, value string, timeout time.Duration) error
+}
+
+var secretOptions = map[string]secretOption{
+	"db-pass": {
+		Option:       "db.db-pass",
+		SecretOption: "db.db-pass-secret",
+		Generate:     true,
+		Change:       changeDBPassword,
+	},
+	"broker-url": {
+		Option:       "messaging.broker-url",
+		SecretOption: "messaging.broker-url-secret",
+	},
+}
+
+func secretOptionNames() []string {
+	names := make([]string, 0, len(secretOptions))
+	for name := range secretOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// defaultSecretPath returns the path a secret is stored under when none is given: the service
+// name is used as a prefix so that multiple Scale instances do not collide.
+func defaultSecretPath(name string) string {
+	return strings.Trim(config.ServiceName, "/") + "/" + name
+}
+
+// secretStore is the subset of the DC/OS secret store used by the CLI.
+type secretStore interface {
+	Get(path string) (string, error)
+	Create(path, value string) error
+	Update(path, value string) error
+	List(prefix string) ([]string, error)
+}
+
+// dcosSecretStore talks to the secrets API exposed by the cluster's admin router.
+type dcosSecretStore struct {
+	store string
+	// request is swapped out when pointing the store at something other than the cluster.
+	request func(method, urlPath string, payload []byte, contentType string) ([]byte, error)
+}
+
+func newSecretStore() *dcosSecretStore {
+	return &dcosSecretStore{store: "default", request: clusterRequest}
+}
+
+type secretValue struct {
+	Value string `json:"value"`
+}
+
+func (s *dcosSecretStore) secretPath(path string) string {
+	return fmt.Sprintf("secrets/v1/secret/%s/%s", s.store, strings.Trim(path, "/"))
+}
+
+func (s *dcosSecretStore) Get(path string) (string, error) {
+	body, err := s.request("GET", s.secretPath(path), nil, "")
+	if err != nil {
+		return "", err
+	}
+	var secret secretValue
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("Failed to parse secret %s: %s", path, err)
+	}
+	return secret.Value, nil
+}
+
+func (s *dcosSecretStore) Create(path, value string) error {
+	return s.write("PUT", path, value)
+}
+
+func (s *dcosSecretStore) Update(path, value string) error {
+	return s.write("PATCH", path, value)
+}
+
+func (s *dcosSecretStore) write(method, path, value string) error {
+	payload, err := json.Marshal(secretValue{Value: value})
+	if err != nil {
+		return err
+	}
+	_, err = s.request(method, s.secretPath(path), payload, "application/json")
+	return err
+}
+
+func (s *dcosSecretStore) List(prefix string) ([]string, error) {
+	body, err := s.request("GET", s.secretPath(prefix)+"?list=true", nil, "")
+	if isStatus(err, 404) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Array []string `json:"array"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("Failed to parse secret list under %s: %s", prefix, err)
+	}
+	sort.Strings(list.Array)
+	return list.Array, nil
+}
+
+// secretOptionValue returns the value of a secret option from flattened options, reading it from
+// the secret store when the options point there instead of holding it in plaintext.
+func secretOptionValue(store secretStore, options map[string]interface{}, name string) (string, error) {
+	option := secretOptions[name]
+	if path := optionString(options, option.SecretOption, ""); len(path) != 0 {
+		value, err := store.Get(path)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read %s from secret %s: %s", option.Option, path, err)
+		}
+		return value, nil
+	}
+	return optionString(options, option.Option, ""), nil
+}
+
+// putSecret creates the secret, or updates it if it already exists.
+func putSecret(store secretStore, path, value string) error {
+	err := store.Create(path, value)
+	if isStatus(err, 409) {
+		return store.Update(path, value)
+	}
+	return err
+}
+
+func readSecretValue(in io.Reader) (string, error) {
+	value, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	value = strings.TrimRight(value, "\r\n")
+	if len(value) == 0 {
+		return "", fmt.Errorf("No secret value was provided on stdin")
+	}
+	return value, nil
+}
+
+func generateSecretValue() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// changeDBPassword changes the password of db.db-user in the database. Postgres only reads
+// POSTGRES_PASSWORD when it initializes its data, so storing a new db-pass alone would lock Scale
+// out of its own database at the next restart.
+func changeDBPassword(options map[string]interface{}, current, value string, timeout time.Duration) error {
+	address, _ := dbAddress(options)
+	user := optionString(options, "db.db-user", "scale")
+	conn, err := pgConnect(pgConfig{
+		Address:  address,
+		User:     user,
+		Password: current,
+		Database: optionString(options, "db.db-name", "scale"),
+		Timeout:  timeout,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to log in to the database at %s with the current password: %s", address, err)
+	}
+	defer conn.Close()
+	if _, err := conn.query(fmt.Sprintf("ALTER USER %s PASSWORD %s", pgQuoteIdentifier(user), pgQuoteLiteral(value))); err != nil {
+		return fmt.Errorf("Failed to change the password of %s: %s", user, err)
+	}
+	return nil
+}
+
+// secretOptionsSnippet returns the options JSON which points the service at a stored secret.
+func secretOptionsSnippet(option secretOption, path string) string {
+	keys := strings.SplitN(option.SecretOption, ".", 2)
+	snippet, _ := json.MarshalIndent(map[string]map[string]string{
+		keys[0]: {keys[1]: path},
+	}, "", "  ")
+	return string(snippet)
+}
+
+type secretsHandler struct {
+	name        string
+	path        string
+	valueStdin  bool
+	optionsFile string
+	timeout     time.Duration
+	store       secretStore
+}
+
+func (cmd *secretsHandler) option() secretOption {
+	return secretOptions[cmd.name]
+}
+
+func (cmd *secretsHandler) secretPath() string {
+	if len(cmd.path) != 0 {
+		return cmd.path
+	}
+	return defaultSecretPath(cmd.name)
+}
+
+func (cmd *secretsHandler) handleSet(c *kingpin.ParseContext) error {
+	value, err := readSecretValue(os.Stdin)
+	if err != nil {
+		return err
+	}
+	path := cmd.secretPath()
+	if err := putSecret(cmd.store, path, value); err != nil {
+		return fmt.Errorf("Failed to store secret %s: %s", path, err)
+	}
+	option := cmd.option()
+	client.PrintMessage("Stored %s in secret %s.", option.Option, path)
+	client.PrintMessage("Reference it from your options file instead of the plaintext value:\n%s",
+		secretOptionsSnippet(option, path))
+	return nil
+}
+
+func (cmd *secretsHandler) handleList(c *kingpin.ParseContext) error {
+	prefix := strings.Trim(config.ServiceName, "/")
+	if len(cmd.path) != 0 {
+		prefix = strings.Trim(cmd.path, "/")
+	}
+	paths, err := cmd.store.List(prefix)
+	if err != nil {
+		return fmt.Errorf("Failed to list secrets under %s: %s", prefix, err)
+	}
+	if len(paths) == 0 {
+		client.PrintMessage("No secrets found under %s.", prefix)
+		return nil
+	}
+	for _, path := range paths {
+		fullPath := prefix + "/" + path
+		usage := ""
+		for _, name := range secretOptionNames() {
+			if defaultSecretPath(name) == fullPath {
+				usage = fmt.Sprintf(" (default for %s)", secretOptions[name].SecretOption)
+			}
+		}
+		client.PrintMessage("%s%s", fullPath, usage)
+	}
+	return nil
+}
+
+func (cmd *secretsHandler) handleRotate(c *kingpin.ParseContext) error {
+	path := cmd.secretPath()
+	current, err := cmd.store.Get(path)
+	if err != nil {
+		if isStatus(err, 404) {
+			return fmt.Errorf("Secret %s does not exist, use 'secrets set' to create it", path)
+		}
+		return fmt.Errorf("Failed to read secret %s: %s", path, err)
+	}
+	option := cmd.option()
+	var value string
+	switch {
+	case cmd.valueStdin:
+		value, err = readSecretValue(os.Stdin)
+	case option.Generate:
+		value, err = generateSecretValue()
+	default:
+		err = fmt.Errorf("A new value for %s cannot be generated, pass it with --value-stdin", option.Option)
+	}
+	if err != nil {
+		return err
+	}
+	if option.Change != nil {
+		options, err := serviceOptions(cmd.optionsFile)
+		if err != nil {
+			return err
+		}
+		if err := option.Change(options, current, value, cmd.timeout); err != nil {
+			return fmt.Errorf("%s, secret %s is unchanged", err, path)
+		}
+		if err := cmd.store.Update(path, value); err != nil {
+			if revertErr := option.Change(options, value, current, cmd.timeout); revertErr != nil {
+				// the secret no longer matches what is in use, and nobody else knows the new value
+				client.PrintMessage("The new value of %s is in use but could not be stored or reverted: %s", option.Option, value)
+				return fmt.Errorf("Failed to update secret %s: %s, and reverting failed: %s. Store the value above with 'secrets set' before restarting Scale", path, err, revertErr)
+			}
+			return fmt.Errorf("Failed to update secret %s: %s, the change was reverted", path, err)
+		}
+	} else if err := cmd.store.Update(path, value); err != nil {
+		return fmt.Errorf("Failed to update secret %s: %s", path, err)
+	}
+	client.PrintMessage("Rotated secret %s.", path)
+	client.PrintMessage("Pods only read %s at launch: restart them for the new value to take effect.", option.Option)
+	return nil
+}
+
+func handleSecretsSection(app *kingpin.Application) {
+	cmd := &secretsHandler{store: newSecretStore()}
+	secrets := app.Command("secrets", "Manage credentials kept in the DC/OS secret store")
+
+	set := secrets.Command("set", "Store a credential read from stdin in the secret store").Action(cmd.handleSet)
+	set.Arg("name", "Credential to store").Required().EnumVar(&cmd.name, secretOptionNames()...)
+	set.Flag("path", "Secret path, defaults to <service-name>/<name>").StringVar(&cmd.path)
+
+	list := secrets.Command("list", "List secrets stored for this service").Action(cmd.handleList)
+	list.Flag("path", "Secret path prefix, defaults to <service-name>").StringVar(&cmd.path)
+
+	rotate := secrets.Command("rotate", "Replace the value of an existing secret").Action(cmd.handleRotate)
+	rotate.Arg("name", "Credential to rotate").Required().EnumVar(&cmd.name, secretOptionNames()...)
+	rotate.Flag("path", "Secret path, defaults to <service-name>/<name>").StringVar(&cmd.path)
+	rotate.Flag("value-stdin", "Read the new value from stdin instead of generating one").BoolVar(&cmd.valueStdin)
+	rotate.Flag("options", "Options file to take the database settings from instead of the installed service").StringVar(&cmd.optionsFile)
+	rotate.Flag("timeout", "Timeout for the database connection when rotating db-pass").Default("10s").DurationVar(&cmd.timeout)
+}
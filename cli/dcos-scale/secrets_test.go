@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mesosphere/dcos-commons/cli/config"
+)
+
+// secretStoreStandIn serves the parts of the DC/OS secrets API the CLI uses from memory.
+type secretStoreStandIn struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func (s *secretStoreStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "token=test-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	const prefix = "/secrets/v1/secret/default/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case "GET":
+		if r.URL.Query().Get("list") == "true" {
+			list := []string{}
+			for key := range s.secrets {
+				if strings.HasPrefix(key, path+"/") {
+					list = append(list, strings.TrimPrefix(key, path+"/"))
+				}
+			}
+			if len(list) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string][]string{"array": list})
+			return
+		}
+		value, ok := s.secrets[path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(secretValue{Value: value})
+	case "PUT", "PATCH":
+		_, exists := s.secrets[path]
+		if r.Method == "PUT" && exists {
+			http.Error(w, "already exists", http.StatusConflict)
+			return
+		}
+		if r.Method == "PATCH" && !exists {
+			http.NotFound(w, r)
+			return
+		}
+		var secret secretValue
+		if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.secrets[path] = secret.Value
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *secretStoreStandIn) value(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secrets[path]
+}
+
+// withSecretStore points the cluster URL at a stand-in holding the given secrets, until the
+// returned function is called.
+func withSecretStore(secrets map[string]string) (*secretStoreStandIn, func()) {
+	standIn := &secretStoreStandIn{secrets: secrets}
+	server := httptest.NewServer(standIn)
+	url, token, service := config.DcosUrl, config.DcosAuthToken, config.ServiceName
+	config.DcosUrl, config.DcosAuthToken, config.ServiceName = server.URL, "test-token", "scale"
+	return standIn, func() {
+		server.Close()
+		config.DcosUrl, config.DcosAuthToken, config.ServiceName = url, token, service
+	}
+}
+
+// withStdin feeds a value to os.Stdin until the returned function is called.
+func withStdin(t *testing.T, value string) func() {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteString(value)
+	writer.Close()
+	stdin := os.Stdin
+	os.Stdin = reader
+	return func() {
+		os.Stdin = stdin
+		reader.Close()
+	}
+}
+
+func TestPutSecretCreatesThenUpdates(t *testing.T) {
+	standIn, restore := withSecretStore(map[string]string{})
+	defer restore()
+	store := newSecretStore()
+	if err := putSecret(store, "scale/db-pass", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := putSecret(store, "/scale/db-pass/", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if got := standIn.value("scale/db-pass"); got != "second" {
+		t.Errorf("stored value is %q, expected %q", got, "second")
+	}
+}
+
+func TestSecretStoreGetAndList(t *testing.T) {
+	_, restore := withSecretStore(map[string]string{"scale/db-pass": "hunter2", "scale/broker-url": "amqp://a:b@c//", "other/db-pass": "x"})
+	defer restore()
+	store := newSecretStore()
+	value, err := store.Get("scale/db-pass")
+	if err != nil || value != "hunter2" {
+		t.Errorf("Get returned %q, %v", value, err)
+	}
+	if _, err := store.Get("scale/missing"); !isStatus(err, 404) {
+		t.Errorf("Get of a missing secret returned %v, expected a 404", err)
+	}
+	paths, err := store.List("scale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(paths, ",") != "broker-url,db-pass" {
+		t.Errorf("List returned %v", paths)
+	}
+	if paths, err := store.List("empty"); err != nil || len(paths) != 0 {
+		t.Errorf("List of an empty prefix returned %v, %v", paths, err)
+	}
+}
+
+func TestSecretOptionValueFallsBackToPlaintext(t *testing.T) {
+	_, restore := withSecretStore(map[string]string{"scale/db-pass": "from-store"})
+	defer restore()
+	tests := []struct {
+		options  map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"db.db-pass": "plaintext"}, "plaintext"},
+		{map[string]interface{}{"db.db-pass": "plaintext", "db.db-pass-secret": ""}, "plaintext"},
+		{map[string]interface{}{"db.db-pass": "plaintext", "db.db-pass-secret": "scale/db-pass"}, "from-store"},
+		{map[string]interface{}{}, ""},
+	}
+	for _, test := range tests {
+		value, err := secretOptionValue(newSecretStore(), test.options, "db-pass")
+		if err != nil || value != test.expected {
+			t.Errorf("secretOptionValue(%v) returned %q, %v, expected %q", test.options, value, err, test.expected)
+		}
+	}
+	_, err := secretOptionValue(newSecretStore(), map[string]interface{}{"db.db-pass-secret": "scale/missing"}, "db-pass")
+	if err == nil || !strings.Contains(err.Error(), "scale/missing") {
+		t.Errorf("reading a missing secret returned %v", err)
+	}
+}
+
+func TestRotateReplacesValue(t *testing.T) {
+	standIn, restore := withSecretStore(map[string]string{"scale/broker-url": "amqp://old@host//"})
+	defer restore()
+	defer withStdin(t, "amqp://new@host//\n")()
+	cmd := &secretsHandler{name: "broker-url", valueStdin: true, store: newSecretStore()}
+	if err := cmd.handleRotate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := standIn.value("scale/broker-url"); got != "amqp://new@host//" {
+		t.Errorf("rotated value is %q", got)
+	}
+}
+
+func TestRotateMissingSecret(t *testing.T) {
+	_, restore := withSecretStore(map[string]string{})
+	defer restore()
+	cmd := &secretsHandler{name: "db-pass", store: newSecretStore()}
+	if err := cmd.handleRotate(nil); err == nil || !strings.Contains(err.Error(), "secrets set") {
+		t.Errorf("rotating a missing secret returned %v", err)
+	}
+}
+
+func TestRotateDBPassKeepsSecretWhenDatabaseRefuses(t *testing.T) {
+	standIn, restore := withSecretStore(map[string]string{"scale/db-pass": "current"})
+	defer restore()
+	// a port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	optionsFile := filepath.Join(dir, "options.json")
+	options := `{"db": {"db-host": "` + host + `", "db-port": ` + port + `}}`
+	if err := ioutil.WriteFile(optionsFile, []byte(options), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &secretsHandler{name: "db-pass", optionsFile: optionsFile, store: newSecretStore()}
+	if err := cmd.handleRotate(nil); err == nil || !strings.Contains(err.Error(), "unchanged") {
+		t.Errorf("rotate returned %v, expected the secret to be left unchanged", err)
+	}
+	if got := standIn.value("scale/db-pass"); got != "current" {
+		t.Errorf("secret was changed to %q although the database password was not", got)
+	}
+}
+
+func TestSecretOptionNames(t *testing.T) {
+	names := secretOptionNames()
+	if !sort.StringsAreSorted(names) || strings.Join(names, ",") != "broker-url,db-pass" {
+		t.Errorf("secretOptionNames returned %v", names)
+	}
+}
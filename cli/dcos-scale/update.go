@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"github.com/mesosphere/dcos-commons/cli/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	serviceDescribeRequest  = "application/vnd.dcos.service.describe-request+json;charset=utf-8;version=v1"
+	serviceDescribeResponse = "application/vnd.dcos.service.describe-response+json;charset=utf-8;version=v1"
+	serviceUpdateRequest    = "application/vnd.dcos.service.update-request+json;charset=utf-8;version=v1"
+	serviceUpdateResponse   = "application/vnd.dcos.service.update-response+json;charset=utf-8;version=v1"
+)
+
+func serviceAppID() string {
+	return "/" + strings.Trim(config.ServiceName, "/")
+}
+
+// describeServiceOptions returns the options the service currently runs with, as resolved by
+// the package service from its defaults and the user's options.
+func describeServiceOptions() (map[string]interface{}, error) {
+	payload, err := json.Marshal(map[string]string{"appId": serviceAppID()})
+	if err != nil {
+		return nil, err
+	}
+	body, err := doRequestHeaders("POST", dcosURL()+"/cosmos/service/describe", payload, map[string]string{
+		"Content-Type": serviceDescribeRequest,
+		"Accept":       serviceDescribeResponse,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe service %s: %s", config.ServiceName, err)
+	}
+	var response struct {
+		ResolvedOptions map[string]interface{} `json:"resolvedOptions"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("Failed to parse description of service %s: %s", config.ServiceName, err)
+	}
+	return response.ResolvedOptions, nil
+}
+
+// updateServiceOptions submits options to be merged onto those the service runs with.
+func updateServiceOptions(options map[string]interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"appId":   serviceAppID(),
+		"options": options,
+		"replace": false,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = doRequestHeaders("POST", dcosURL()+"/cosmos/service/update", payload, map[string]string{
+		"Content-Type": serviceUpdateRequest,
+		"Accept":       serviceUpdateResponse,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update service %s: %s", config.ServiceName, err)
+	}
+	return nil
+}
+
+type updatePreview struct {
+	Changes  []optionChange      `json:"changes" yaml:"changes"`
+	Pods     map[string][]string `json:"relaunchedPods" yaml:"relaunchedPods"`
+	Added    []string            `json:"addedPhases" yaml:"addedPhases"`
+	Removed  []string            `json:"removedPhases" yaml:"removedPhases"`
+	Warnings []string            `json:"warnings" yaml:"warnings"`
+}
+
+func (p *updatePreview) empty() bool {
+	return len(p.Changes) == 0
+}
+
+func optionValuesEqual(a, b interface{}) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
+
+func optionIsEmpty(value interface{}) bool {
+	return value == nil || value == ""
+}
+
+// previewUpdate works out what merging newOptions onto the current options would do.
+func previewUpdate(current, newOptions map[string]interface{}) *updatePreview {
+	preview := &updatePreview{Pods: map[string][]string{}}
+	before := flattenOptions(current)
+	after := flattenOptions(mergeOptions(current, newOptions))
+
+	changed := map[string]bool{}
+	for path, value := range after {
+		if !optionValuesEqual(before[path], value) {
+			changed[path] = true
+			preview.Changes = append(preview.Changes, optionChange{Path: path, From: before[path], To: value})
+		}
+	}
+	sortOptionChanges(preview.Changes)
+
+	for name := range envForOptions(defaultEnvOptions, changed) {
+		for _, pod := range podsUsingEnv(defaultPodEnv, map[string]bool{name: true}) {
+			preview.Pods[pod] = append(preview.Pods[pod], name)
+		}
+	}
+	for pod := range preview.Pods {
+		sort.Strings(preview.Pods[pod])
+	}
+
+	// a supporting service is deployed by scale-deploy when its external address is left empty
+	for name, phase := range defaultPhaseToggles {
+		for _, path := range defaultEnvOptions[name] {
+			wasDeployed, isDeployed := optionIsEmpty(before[path]), optionIsEmpty(after[path])
+			if !wasDeployed && isDeployed {
+				preview.Added = append(preview.Added, phase)
+			} else if wasDeployed && !isDeployed {
+				preview.Removed = append(preview.Removed, phase)
+			}
+		}
+	}
+	sort.Strings(preview.Added)
+	sort.Strings(preview.Removed)
+
+	if changed["service.name"] {
+		preview.Warnings = append(preview.Warnings, "service.name cannot be changed by an update")
+	}
+	if _, ok := preview.Pods["db"]; ok && optionIsEmpty(after["db.db-host"]) {
+		preview.Warnings = append(preview.Warnings, "the sample database pod db-0 will be relaunched")
+	}
+	return preview
+}
+
+func printUpdatePreview(preview *updatePreview) {
+	if preview.empty() {
+		client.PrintMessage("The options do not change the service.")
+		return
+	}
+	client.PrintMessage("Changed options:")
+	for _, change := range preview.Changes {
+		client.PrintMessage("  %s: %v -> %v", change.Path, change.From, change.To)
+	}
+	pods := make([]string, 0, len(preview.Pods))
+	for pod := range preview.Pods {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	if len(pods) == 0 {
+		client.PrintMessage("\nNo pods will be relaunched.")
+	} else {
+		client.PrintMessage("\nPods to be relaunched:")
+		for _, pod := range pods {
+			client.PrintMessage("  %s (%s)", pod, strings.Join(preview.Pods[pod], ", "))
+		}
+	}
+	for _, phase := range preview.Added {
+		client.PrintMessage("\nPhase %s will be added to the scale-deploy plan.", phase)
+	}
+	for _, phase := range preview.Removed {
+		client.PrintMessage("\nPhase %s will be removed from the scale-deploy plan.", phase)
+	}
+	for _, warning := range preview.Warnings {
+		client.PrintMessage("\nWARNING: %s", warning)
+	}
+}
+
+// confirmTyped asks the user to type an expected value, eg the service name, before continuing.
+func confirmTyped(in io.Reader, prompt, expected string) bool {
+	fmt.Printf("%s\nType '%s' to continue: ", prompt, expected)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(answer) == expected
+}
+
+type updateHandler struct {
+	optionsFile string
+	yes         bool
+	// jsonOptionsFile is the JSON copy of a YAML or TOML options file passed to update start.
+	jsonOptionsFile string
+}
+
+func (cmd *updateHandler) preview() (map[string]interface{}, *updatePreview, error) {
+	newOptions, err := readOptionsFile(cmd.optionsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := describeServiceOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	return newOptions, previewUpdate(current, newOptions), nil
+}
+
+func (cmd *updateHandler) handlePreview(c *kingpin.ParseContext) error {
+	_, preview, err := cmd.preview()
+	if err != nil {
+		return err
+	}
+	if printed, err := printStructured(preview); printed {
+		return err
+	}
+	printUpdatePreview(preview)
+	return nil
+}
+
+// confirmStart runs before the default sections' update start: with an options file it shows
+// what the update will relaunch and asks for confirmation before the update is submitted. Updates
+// to another package version are left to the package service, whose new defaults the preview
+// cannot know.
+func (cmd *updateHandler) confirmStart(c *kingpin.ParseContext) error {
+	cmd.optionsFile = flagValue(c, "options")
+	if len(cmd.optionsFile) == 0 {
+		return nil
+	}
+	newOptions, err := readOptionsFile(cmd.optionsFile)
+	if err != nil {
+		return err
+	}
+	if len(flagValue(c, "package-version")) == 0 {
+		current, err := describeServiceOptions()
+		if err != nil {
+			return err
+		}
+		preview := previewUpdate(current, newOptions)
+		printUpdatePreview(preview)
+		if !preview.empty() && !cmd.yes &&
+			!confirmTyped(os.Stdin, "\nThis will update the service configuration.", config.ServiceName) {
+			return fmt.Errorf("Update cancelled")
+		}
+	}
+	return cmd.passOptionsAsJSON(c, newOptions)
+}
+
+// passOptionsAsJSON points the default sections' --options at a JSON copy of the options file,
+// as their update start reads the file as JSON. Options from stdin have been read already and
+// are passed the same way.
+func (cmd *updateHandler) passOptionsAsJSON(c *kingpin.ParseContext, newOptions map[string]interface{}) error {
+	if cmd.optionsFile != "-" && optionsFormatOf(cmd.optionsFile) == "json" {
+		return nil
+	}
+	data, err := json.Marshal(newOptions)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile("", "scale-options")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	for _, element := range c.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok && flag.Model().Name == "options" {
+			cmd.jsonOptionsFile = file.Name()
+			return flag.Model().Value.Set(file.Name())
+		}
+	}
+	os.Remove(file.Name())
+	return nil
+}
+
+// removeJSONOptions removes the JSON copy of the options file once update start has read it.
+func (cmd *updateHandler) removeJSONOptions(c *kingpin.ParseContext) error {
+	if len(cmd.jsonOptionsFile) != 0 {
+		os.Remove(cmd.jsonOptionsFile)
+	}
+	return nil
+}
+
+func (cmd *updateHandler) handleStart(c *kingpin.ParseContext) error {
+	newOptions, preview, err := cmd.preview()
+	if err != nil {
+		return err
+	}
+	printUpdatePreview(preview)
+	if preview.empty() {
+		return nil
+	}
+	if !cmd.yes && !confirmTyped(os.Stdin, "\nThis will update the service configuration.", config.ServiceName) {
+		return fmt.Errorf("Update cancelled")
+	}
+	if err := updateServiceOptions(newOptions); err != nil {
+		return err
+	}
+	client.PrintMessage("Update started. Follow it with 'dcos scale plan show deploy'.")
+	return nil
+}
+
+// handleUpdateSection adds preview to the update command of the default sections and a
+// confirmation to its start. Without that command, update start is provided here.
+func handleUpdateSection(app *kingpin.Application) {
+	cmd := &updateHandler{}
+	update := app.GetCommand("update")
+	if update == nil {
+		update = app.Command("update", "Update the service configuration")
+		start := update.Command("start", "Preview an options change, confirm and apply it").Action(cmd.handleStart)
+		start.Flag("options", "Options file (JSON, YAML or TOML) with the new values").Required().StringVar(&cmd.optionsFile)
+		start.Flag("yes", "Do not ask for confirmation").BoolVar(&cmd.yes)
+	} else if start := update.GetCommand("start"); start != nil {
+		start.Flag("yes", "Do not ask for confirmation of an options change").BoolVar(&cmd.yes)
+		start.PreAction(cmd.confirmStart).Action(cmd.removeJSONOptions)
+	}
+
+	preview := update.Command("preview", "Show which pods and phases an options change affects").Action(cmd.handlePreview)
+	preview.Flag("options", "Options file (JSON, YAML or TOML) with the new values").Required().StringVar(&cmd.optionsFile)
+}
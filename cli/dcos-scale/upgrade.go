@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type optionChange struct {
+	Path string      `json:"path" yaml:"path"`
+	From interface{} `json:"from,omitempty" yaml:"from,omitempty"`
+	To   interface{} `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+type optionRename struct {
+	From   string `json:"from" yaml:"from"`
+	To     string `json:"to" yaml:"to"`
+	Reason string `json:"reason" yaml:"reason"`
+}
+
+type envChange struct {
+	Name string   `json:"name" yaml:"name"`
+	From []string `json:"from" yaml:"from"`
+	To   []string `json:"to" yaml:"to"`
+}
+
+type upgradePlan struct {
+	From           string              `json:"from" yaml:"from"`
+	To             string              `json:"to" yaml:"to"`
+	Added          []optionChange      `json:"added" yaml:"added"`
+	Removed        []optionChange      `json:"removed" yaml:"removed"`
+	Renamed        []optionRename      `json:"renamed" yaml:"renamed"`
+	DefaultChanges []optionChange      `json:"defaultChanges" yaml:"defaultChanges"`
+	TypeChanges    []optionChange      `json:"typeChanges" yaml:"typeChanges"`
+	EnvChanges     []envChange         `json:"envChanges" yaml:"envChanges"`
+	ImageChanges   []optionChange      `json:"imageChanges" yaml:"imageChanges"`
+	RestartedPods  map[string][]string `json:"restartedPods" yaml:"restartedPods"`
+}
+
+// planUpgrade compares two versions of the packaging. Pod restarts are predicted for a service
+// which runs with default values: options overridden by the user only restart a pod if their
+// env mapping or the pod itself changes.
+func planUpgrade(from, to *packaging) *upgradePlan {
+	plan := &upgradePlan{From: from.Version, To: to.Version, RestartedPods: map[string][]string{}}
+	fromOptions := flattenSchema(from.Config)
+	toOptions := flattenSchema(to.Config)
+
+	added := map[string]*schemaOption{}
+	removed := map[string]*schemaOption{}
+	for path, option := range toOptions {
+		if _, ok := fromOptions[path]; !ok {
+			added[path] = option
+		}
+	}
+	for path, option := range fromOptions {
+		previous, ok := toOptions[path]
+		if !ok {
+			removed[path] = option
+			continue
+		}
+		if previous.Type != option.Type {
+			plan.TypeChanges = append(plan.TypeChanges, optionChange{Path: path, From: option.Type, To: previous.Type})
+		}
+		if !reflect.DeepEqual(previous.Default, option.Default) {
+			plan.DefaultChanges = append(plan.DefaultChanges, optionChange{Path: path, From: option.Default, To: previous.Default})
+		}
+	}
+
+	// an option is renamed when the same env var is rendered from it before and from the new option
+	// after, or failing that when an added option has the same description as a removed one
+	for oldPath := range removed {
+		for newPath, option := range added {
+			reason := ""
+			for name, refs := range from.EnvOptions {
+				if containsString(refs, oldPath) && containsString(to.EnvOptions[name], newPath) {
+					reason = "both render " + name
+					break
+				}
+			}
+			if len(reason) == 0 && len(option.Description) != 0 && option.Description == removed[oldPath].Description {
+				reason = "same description"
+			}
+			if len(reason) != 0 {
+				plan.Renamed = append(plan.Renamed, optionRename{From: oldPath, To: newPath, Reason: reason})
+				delete(removed, oldPath)
+				delete(added, newPath)
+				break
+			}
+		}
+	}
+	for path, option := range added {
+		plan.Added = append(plan.Added, optionChange{Path: path, To: option.Default})
+	}
+	for path, option := range removed {
+		plan.Removed = append(plan.Removed, optionChange{Path: path, From: option.Default})
+	}
+
+	changedEnv := map[string]string{}
+	for _, name := range unionKeys(from.EnvOptions, to.EnvOptions) {
+		fromRefs, toRefs := from.EnvOptions[name], to.EnvOptions[name]
+		if !reflect.DeepEqual(fromRefs, toRefs) {
+			plan.EnvChanges = append(plan.EnvChanges, envChange{Name: name, From: fromRefs, To: toRefs})
+			changedEnv[name] = "env " + name + " is rendered differently"
+		}
+	}
+
+	fromImages, toImages := dockerImages(from.Resource), dockerImages(to.Resource)
+	changedOptions := map[string]string{}
+	for _, asset := range unionKeys(stringMapKeys(fromImages), stringMapKeys(toImages)) {
+		if fromImages[asset] != toImages[asset] {
+			plan.ImageChanges = append(plan.ImageChanges, optionChange{Path: asset, From: fromImages[asset], To: toImages[asset]})
+			changedOptions["resource.assets.container.docker."+asset] = "image " + asset + " changes"
+		}
+	}
+	for _, change := range plan.DefaultChanges {
+		changedOptions[change.Path] = "default of " + change.Path + " changes"
+	}
+	for name, refs := range to.EnvOptions {
+		for _, ref := range refs {
+			if reason, ok := changedOptions[ref]; ok {
+				changedEnv[name] = reason
+			}
+		}
+	}
+
+	for pod, vars := range to.PodEnv {
+		for _, name := range vars {
+			if reason, ok := changedEnv[name]; ok && !containsString(plan.RestartedPods[pod], reason) {
+				plan.RestartedPods[pod] = append(plan.RestartedPods[pod], reason)
+			}
+		}
+		if from.PodTemplates != nil && to.PodTemplates != nil && from.PodTemplates[pod] != to.PodTemplates[pod] {
+			plan.RestartedPods[pod] = append(plan.RestartedPods[pod], "pod definition in svc.yml changes")
+		}
+	}
+
+	sortOptionChanges(plan.Added)
+	sortOptionChanges(plan.Removed)
+	sortOptionChanges(plan.DefaultChanges)
+	sortOptionChanges(plan.TypeChanges)
+	sortOptionChanges(plan.ImageChanges)
+	sort.Slice(plan.Renamed, func(i, j int) bool { return plan.Renamed[i].From < plan.Renamed[j].From })
+	for pod := range plan.RestartedPods {
+		sort.Strings(plan.RestartedPods[pod])
+	}
+	return plan
+}
+
+func sortOptionChanges(changes []optionChange) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func stringMapKeys(m map[string]string) map[string][]string {
+	keys := map[string][]string{}
+	for key := range m {
+		keys[key] = nil
+	}
+	return keys
+}
+
+func unionKeys(a, b map[string][]string) []string {
+	keys := []string{}
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printUpgradePlan(plan *upgradePlan) {
+	client.PrintMessage("Upgrade plan from %s to %s", plan.From, plan.To)
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		client.PrintMessage("\n%s:", title)
+		for _, line := range lines {
+			client.PrintMessage("  %s", line)
+		}
+	}
+	var lines []string
+	for _, change := range plan.Added {
+		lines = append(lines, fmt.Sprintf("%s (default: %v)", change.Path, change.To))
+	}
+	section("New options", lines)
+	lines = nil
+	for _, change := range plan.Removed {
+		lines = append(lines, change.Path)
+	}
+	section("Removed options", lines)
+	lines = nil
+	for _, rename := range plan.Renamed {
+		lines = append(lines, fmt.Sprintf("%s -> %s (%s)", rename.From, rename.To, rename.Reason))
+	}
+	section("Renamed options", lines)
+	lines = nil
+	for _, change := range plan.DefaultChanges {
+		lines = append(lines, fmt.Sprintf("%s: %v -> %v", change.Path, change.From, change.To))
+	}
+	section("Changed defaults", lines)
+	lines = nil
+	for _, change := range plan.TypeChanges {
+		lines = append(lines, fmt.Sprintf("%s: %v -> %v", change.Path, change.From, change.To))
+	}
+	section("Changed types", lines)
+	lines = nil
+	for _, change := range plan.EnvChanges {
+		lines = append(lines, fmt.Sprintf("%s: [%s] -> [%s]", change.Name, strings.Join(change.From, ", "), strings.Join(change.To, ", ")))
+	}
+	section("Changed env mappings", lines)
+	lines = nil
+	for _, change := range plan.ImageChanges {
+		lines = append(lines, fmt.Sprintf("%s: %v -> %v", change.Path, change.From, change.To))
+	}
+	section("Image changes", lines)
+
+	if len(plan.RestartedPods) == 0 {
+		client.PrintMessage("\nNo pods are expected to restart.")
+		return
+	}
+	lines = nil
+	pods := make([]string, 0, len(plan.RestartedPods))
+	for pod := range plan.RestartedPods {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	for _, pod := range pods {
+		lines = append(lines, fmt.Sprintf("%s: %s", pod, strings.Join(plan.RestartedPods[pod], "; ")))
+	}
+	section("WARNING: these pods will be restarted", lines)
+}
+
+type upgradeHandler struct {
+	from        string
+	to          string
+	packageName string
+	repoDir     string
+}
+
+func (cmd *upgradeHandler) handlePlan(c *kingpin.ParseContext) error {
+	from, err := loadPackaging(cmd.packageName, cmd.repoDir, cmd.from)
+	if err != nil {
+		return err
+	}
+	to, err := loadPackaging(cmd.packageName, cmd.repoDir, cmd.to)
+	if err != nil {
+		return err
+	}
+	plan := planUpgrade(from, to)
+	if printed, err := printStructured(plan); printed {
+		return err
+	}
+	printUpgradePlan(plan)
+	return nil
+}
+
+func handleUpgradeSection(app *kingpin.Application) {
+	cmd := &upgradeHandler{}
+	upgrade := app.Command("upgrade", "Plan package upgrades")
+
+	plan := upgrade.Command("plan", "Compare the options, env mappings and images of two package versions").Action(cmd.handlePlan)
+	plan.Flag("from", "Version currently installed, or a directory holding its packaging files").Required().StringVar(&cmd.from)
+	plan.Flag("to", "Version to upgrade to, or a directory holding its packaging files").Required().StringVar(&cmd.to)
+	plan.Flag("package-name", "Name of the package in the package repository").Default("scale").StringVar(&cmd.packageName)
+	plan.Flag("repo-dir", "Directory with one packaging directory per version, read instead of the package repository").StringVar(&cmd.repoDir)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// An optionValidator checks flattened options for mistakes which the package's JSON schema lets
+// through and which would otherwise only surface as a stuck deployment. It returns one message
+// per problem found.
+type optionValidator struct {
+	Name  string
+	Check func(options map[string]interface{}) []string
+}
+
+var optionValidators = []optionValidator{
+	{Name: "node.placement_constraint", Check: validatePlacementConstraint},
+	{Name: "messaging.broker-url", Check: validateBrokerURL},
+}
+
+func validatePlacementConstraint(options map[string]interface{}) []string {
+	if _, err := parsePlacementConstraint(optionString(options, "node.placement_constraint", "")); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// validateBrokerURL checks the broker URL Scale hands to Kombu. A URL kept in the secret store is
+// checked by broker check instead, as reading it needs the cluster.
+func validateBrokerURL(options map[string]interface{}) []string {
+	brokerURL := optionString(options, "messaging.broker-url", "")
+	if len(brokerURL) == 0 {
+		return nil
+	}
+	problems := []string{}
+	if len(optionString(options, "messaging.broker-url-secret", "")) != 0 {
+		problems = append(problems, "messaging.broker-url-secret is set as well and takes precedence")
+	}
+	urls, err := parseKombuURLs(brokerURL)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	for _, parsed := range urls {
+		if parsed.Transport == "memory" {
+			problems = append(problems, "memory:// only passes messages within one process, the scheduler and its jobs would never see each other's messages")
+		}
+	}
+	return problems
+}
+
+func (cmd *optionsHandler) handleValidate(c *kingpin.ParseContext) error {
+	options, err := readOptionsFile(cmd.file)
+	if err != nil {
+		return err
+	}
+	flat := flattenOptions(options)
+	problems := 0
+	for _, validator := range optionValidators {
+		for _, message := range validator.Check(flat) {
+			client.PrintMessage("%s: %s", validator.Name, message)
+			problems++
+		}
+	}
+	if problems != 0 {
+		return fmt.Errorf("%s has %d problems", cmd.file, problems)
+	}
+	client.PrintMessage("%s is valid.", cmd.file)
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/cli/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Exit codes of the wait command, so that pipelines can tell why it gave up.
+const (
+	exitWaitTimeout   = 2
+	exitWaitPlanError = 3
+	exitWaitAPIError  = 4
+)
+
+var waitConditions = []string{"deploy-complete", "scale-api-ready", "queue-empty"}
+
+// waitFailure ends a wait early with the given exit code.
+type waitFailure struct {
+	code int
+	err  error
+}
+
+func (f *waitFailure) Error() string {
+	return f.err.Error()
+}
+
+// isTransientError returns whether an error is expected while the service is still coming up:
+// a connection failure, or the admin router answering 502, 503 or 504 until the webserver is
+// running. Anything else, eg a 404 or a response which cannot be parsed, will not go away by
+// waiting.
+func isTransientError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return isStatus(err, 502) || isStatus(err, 503) || isStatus(err, 504)
+}
+
+func apiFailure(err error) error {
+	if isTransientError(err) {
+		return err
+	}
+	return &waitFailure{code: exitWaitAPIError, err: err}
+}
+
+// waitCondition reports whether the condition holds along with a short description of the
+// current state. A *waitFailure ends the wait, other errors are retried.
+type waitCondition func() (bool, string, error)
+
+func planCompleteCondition(planName string) waitCondition {
+	return func() (bool, string, error) {
+		current, err := fetchPlan(planName)
+		if err != nil {
+			return false, "", apiFailure(err)
+		}
+		switch current.Status {
+		case "COMPLETE":
+			return true, "plan " + planName + " is COMPLETE", nil
+		case "ERROR":
+			return false, "", &waitFailure{code: exitWaitPlanError, err: fmt.Errorf("Plan %s failed: %v", planName, current.Errors)}
+		}
+		return false, "plan " + planName + " is " + current.Status, nil
+	}
+}
+
+func scaleAPIReadyCondition() (bool, string, error) {
+	var status map[string]interface{}
+	if err := scaleAPIGet("status/", &status); err != nil {
+		return false, "", apiFailure(err)
+	}
+	return true, "Scale API is ready", nil
+}
+
+func queueEmptyCondition() (bool, string, error) {
+	var status scaleQueueStatus
+	if err := scaleAPIGet("queue/status/", &status); err != nil {
+		return false, "", apiFailure(err)
+	}
+	queued := status.queued()
+	return queued == 0, fmt.Sprintf("%d jobs queued", queued), nil
+}
+
+// waitFor polls the condition until it holds, it fails or the timeout passes, and returns the
+// exit code for the outcome.
+func waitFor(condition waitCondition, timeout, interval time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+	lastState := ""
+	var lastErr error
+	for {
+		done, state, err := condition()
+		if failure, ok := err.(*waitFailure); ok {
+			return failure.code, failure
+		}
+		lastErr = err
+		if err == nil && state != lastState {
+			client.PrintMessage("[%s] %s", time.Now().Format(time.RFC3339), state)
+			lastState = state
+		}
+		if done {
+			return 0, nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if lastErr != nil {
+				return exitWaitTimeout, fmt.Errorf("Timed out after %s, last error: %s", timeout, lastErr)
+			}
+			return exitWaitTimeout, fmt.Errorf("Timed out after %s, %s", timeout, lastState)
+		}
+		time.Sleep(interval)
+	}
+}
+
+type waitHandler struct {
+	condition string
+	plan      string
+	timeout   time.Duration
+	interval  time.Duration
+}
+
+func (cmd *waitHandler) handleWait(c *kingpin.ParseContext) error {
+	var condition waitCondition
+	switch cmd.condition {
+	case "deploy-complete":
+		condition = planCompleteCondition(cmd.plan)
+	case "scale-api-ready":
+		condition = scaleAPIReadyCondition
+	case "queue-empty":
+		condition = queueEmptyCondition
+	}
+	code, err := waitFor(condition, cmd.timeout, cmd.interval)
+	if code != 0 {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(code)
+	}
+	return nil
+}
+
+func handleWaitSection(app *kingpin.Application) {
+	cmd := &waitHandler{}
+	wait := app.Command("wait", fmt.Sprintf(
+		"Block until a condition holds. Exits %d on timeout, %d if the plan fails and %d on API errors",
+		exitWaitTimeout, exitWaitPlanError, exitWaitAPIError)).Action(cmd.handleWait)
+	wait.Flag("for", "Condition to wait for").Required().EnumVar(&cmd.condition, waitConditions...)
+	wait.Flag("plan", "Plan to wait on for deploy-complete").Default("scale-deploy").StringVar(&cmd.plan)
+	wait.Flag("timeout", "Time to wait before giving up").Default("20m").DurationVar(&cmd.timeout)
+	wait.Flag("interval", "Time between polls").Default("5s").DurationVar(&cmd.interval)
+}
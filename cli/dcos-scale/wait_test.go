@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{refused, true},
+		{&httpError{StatusCode: 502}, true},
+		{&httpError{StatusCode: 503}, true},
+		{&httpError{StatusCode: 504}, true},
+		{&httpError{StatusCode: 404}, false},
+		{&httpError{StatusCode: 401}, false},
+		{&httpError{StatusCode: 500}, false},
+		{errors.New("The Scale API at /service/scale/api serves none of the API versions v6, v5, v4 which this CLI supports"), false},
+		{errors.New("Failed to parse plan deploy: unexpected end of JSON input"), false},
+	}
+	for _, test := range tests {
+		if transient := isTransientError(test.err); transient != test.transient {
+			t.Errorf("isTransientError(%v) is %t", test.err, transient)
+		}
+	}
+}
+
+func TestWaitForExitCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{&httpError{StatusCode: 503}, exitWaitTimeout},
+		{&httpError{StatusCode: 404}, exitWaitAPIError},
+		{errors.New("Failed to parse plan deploy: unexpected end of JSON input"), exitWaitAPIError},
+	}
+	for _, test := range tests {
+		condition := func() (bool, string, error) {
+			return false, "", apiFailure(test.err)
+		}
+		if code, err := waitFor(condition, 10*time.Millisecond, time.Millisecond); code != test.code {
+			t.Errorf("waiting through %v exited with %d (%v), expected %d", test.err, code, err, test.code)
+		}
+	}
+}